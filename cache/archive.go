@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type (
+	// The manifest at the start of an exported cache archive.
+	archiveManifest struct {
+		FormatVersion int              `json:"formatVersion"`
+		Created       time.Time        `json:"created"`
+		Buckets       []archivedBucket `json:"buckets"`
+	}
+
+	archivedBucket struct {
+		Name    string `json:"name"`
+		Host    string `json:"host"`
+		Entries int    `json:"entries"`
+	}
+)
+
+const (
+	archiveFormatVersion = 1
+
+	// Everything in an archive lives in this directory. The manifest
+	// comes first, followed by one file per value at
+	// <bucket>/<key>, using the same names and contents as a FileCache.
+	archiveDirectory    = "kola-cache"
+	archiveManifestName = "manifest.json"
+)
+
+// Write the given buckets from store to w as a gzipped tar archive. Values
+// keep their timestamps and versions, so they expire on the importing
+// machine as they would have here.
+func Export(store Store, w io.Writer, buckets []Bucket) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+
+	manifest := archiveManifest{
+		FormatVersion: archiveFormatVersion,
+		Created:       now,
+	}
+	for _, bucket := range buckets {
+		manifest.Buckets = append(manifest.Buckets, archivedBucket{
+			Name:    bucket.Name,
+			Host:    bucket.Host,
+			Entries: len(bucket.Entries),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeArchiveFile(archive, path.Join(archiveDirectory, archiveManifestName), data, now); err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		cache, err := store.Bucket(bucket.Name, bucket.Host)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range bucket.Entries {
+			value, err := cache.GetStale(entry.Key)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Key, err)
+			}
+			if value == nil {
+				continue
+			}
+
+			data, err := encodeValue(value.Data, value.Timestamp, value.Version)
+			if err != nil {
+				return err
+			}
+
+			name := path.Join(archiveDirectory, bucket.Name, keyToFileName(entry.Key))
+			if err := writeArchiveFile(archive, name, data, value.Timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func writeArchiveFile(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := archive.Write(data)
+	return err
+}
+
+// Load the buckets in an archive written by Export into store, and return
+// the buckets that were imported (with the entries that were stored). A
+// value that is already in the store is only replaced if the imported
+// value is newer. A read-only store would silently discard what we
+// import, so we refuse to import into one.
+func Import(store Store, r io.Reader) ([]Bucket, error) {
+	if ro, ok := store.(interface{ ReadOnly() bool }); ok && ro.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := tar.NewReader(gz)
+
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache archive: %w", err)
+	}
+
+	if header.Name != path.Join(archiveDirectory, archiveManifestName) {
+		return nil, errors.New("invalid cache archive: missing manifest")
+	}
+
+	var manifest archiveManifest
+	if err := json.NewDecoder(archive).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid cache archive: %w", err)
+	}
+
+	if manifest.FormatVersion != archiveFormatVersion {
+		return nil, fmt.Errorf("unsupported cache archive version %d", manifest.FormatVersion)
+	}
+
+	var buckets []Bucket
+	caches := make(map[string]Cache)
+	positions := make(map[string]int)
+
+	for _, bucket := range manifest.Buckets {
+		if bucket.Name == "" || bucket.Name == metadataBucket || strings.ContainsAny(bucket.Name, `/\:*?[`) {
+			return nil, fmt.Errorf("invalid bucket name %q in cache archive", bucket.Name)
+		}
+
+		cache, err := store.Bucket(bucket.Name, bucket.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		caches[bucket.Name] = cache
+		positions[bucket.Name] = len(buckets)
+		buckets = append(buckets, Bucket{Name: bucket.Name, Host: bucket.Host})
+	}
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return buckets, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		dir, fileName := path.Split(strings.TrimPrefix(header.Name, archiveDirectory+"/"))
+		bucketName := strings.TrimSuffix(dir, "/")
+
+		cache, ok := caches[bucketName]
+		if !ok {
+			return buckets, fmt.Errorf("%s: bucket is not in the archive manifest", header.Name)
+		}
+
+		key, err := fileNameToKey(fileName)
+		if err != nil {
+			return buckets, fmt.Errorf("%s: %w", header.Name, err)
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return buckets, err
+		}
+
+		value, err := decodeValue(data)
+		if err != nil {
+			return buckets, fmt.Errorf("%s: %w", header.Name, err)
+		}
+
+		if existing, err := cache.GetStale(key); err == nil && existing != nil && existing.Timestamp.After(value.Timestamp) {
+			continue
+		}
+
+		if err := cache.PutValue(key, value); err != nil {
+			return buckets, err
+		}
+
+		pos := positions[bucketName]
+		buckets[pos].Entries = append(buckets[pos].Entries, Entry{
+			Key:       key,
+			Size:      len(value.Data),
+			Timestamp: value.Timestamp,
+		})
+	}
+
+	return buckets, nil
+}
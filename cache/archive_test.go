@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImport(t *testing.T) {
+	src := startStore(t, newStore(t.TempDir()))
+
+	c, err := src.Bucket("packages", "cluster.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("widget", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := src.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := Export(src, &archive, buckets); err != nil {
+		t.Fatal(err)
+	}
+
+	// While another store holds the lock we can only open a read-only
+	// copy, which would discard anything we imported.
+	dir := t.TempDir()
+	dst := startStore(t, newStore(dir))
+
+	reader := startStore(t, newStore(dir).WithOpenTimeout(100*time.Millisecond))
+	if _, err := Import(reader, bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only store: expected ErrReadOnly, got %v", err)
+	}
+
+	imported, err := Import(dst, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || len(imported[0].Entries) != 1 {
+		t.Fatalf("unexpected import %+v", imported)
+	}
+
+	c, err = dst.Bucket("packages", "cluster.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Get("widget"); err != nil || string(data) != "value" {
+		t.Errorf("imported value: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Values expire this quickly so that we can test expiry. Timestamps are
+// stored with a resolution of one second.
+const testLifetime = 2 * time.Second
+
+// Check the operations common to every Cache.
+func checkCache(t *testing.T, cc Cache) {
+	data, err := cc.Get("missing")
+	if err != nil || data != nil {
+		t.Errorf("missing key returned %q, %v", data, err)
+	}
+
+	if err := cc.Put("a/b", []byte("one")); err != nil {
+		t.Errorf("put: %v", err)
+	}
+	data, err = cc.Get("a/b")
+	if err != nil || !bytes.Equal(data, []byte("one")) {
+		t.Errorf("get returned %q, %v", data, err)
+	}
+
+	if err := cc.PutWithVersion(".hidden", []byte("two"), "v2"); err != nil {
+		t.Errorf("put with version: %v", err)
+	}
+	value, err := cc.GetStale(".hidden")
+	if err != nil || value == nil || value.Version != "v2" || string(value.Data) != "two" {
+		t.Errorf("get stale returned %+v, %v", value, err)
+	}
+
+	if err := cc.PutMany(map[string][]byte{"x": []byte("x"), "y": []byte("y")}); err != nil {
+		t.Errorf("put many: %v", err)
+	}
+	entries, err := cc.Entries()
+	if err != nil || len(entries) != 4 {
+		t.Errorf("expected 4 entries, found %d (%v)", len(entries), err)
+	}
+
+	if err := cc.Delete("x"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if err := cc.Delete("x"); err != nil {
+		t.Errorf("delete missing: %v", err)
+	}
+	if data, _ := cc.Get("x"); data != nil {
+		t.Errorf("deleted key returned %q", data)
+	}
+
+	time.Sleep(testLifetime + 2*time.Second)
+	if data, _ := cc.Get("a/b"); data != nil {
+		t.Errorf("expired key returned %q", data)
+	}
+	if value, _ := cc.GetStale("a/b"); value == nil || string(value.Data) != "one" {
+		t.Errorf("expired key not available with GetStale")
+	}
+}
+
+// Check the Store operations.
+func checkStore(t *testing.T, store Store) {
+	if err := store.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer store.Close()
+
+	cc, err := store.Bucket("bucket1", "https://example.com")
+	if err != nil {
+		t.Fatalf("bucket: %v", err)
+	}
+
+	checkCache(t, cc)
+
+	other, err := store.Bucket("bucket2", "https://example.org")
+	if err != nil {
+		t.Fatalf("second bucket: %v", err)
+	}
+	if err := other.Put("a/b", []byte("other")); err != nil {
+		t.Errorf("put in second bucket: %v", err)
+	}
+	if value, _ := cc.GetStale("a/b"); value == nil || string(value.Data) != "one" {
+		t.Errorf("buckets are not separate")
+	}
+
+	buckets, err := store.Buckets()
+	if err != nil || len(buckets) != 2 {
+		t.Errorf("expected 2 buckets, found %d (%v)", len(buckets), err)
+	}
+	for _, bucket := range buckets {
+		if bucket.Name == "bucket1" && bucket.Host != "https://example.com" {
+			t.Errorf("bucket1 has host %q", bucket.Host)
+		}
+	}
+
+	pruned, err := store.Prune()
+	if err != nil || pruned != 3 {
+		t.Errorf("expected to prune 3 values, pruned %d (%v)", pruned, err)
+	}
+
+	if err := store.DeleteBucket("bucket1"); err != nil {
+		t.Errorf("delete bucket: %v", err)
+	}
+	buckets, _ = store.Buckets()
+	if len(buckets) != 1 || buckets[0].Name != "bucket2" {
+		t.Errorf("unexpected buckets after delete: %+v", buckets)
+	}
+}
+
+// Exercise each backend with the same sequence of operations. The redis
+// backend runs against an in-process fake server.
+func TestBackends(t *testing.T) {
+	server, err := newFakeRedis("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	for _, test := range []struct {
+		name  string
+		store func(dir string) Store
+	}{
+		{"bolt", func(dir string) Store {
+			return newStore(dir).WithLifetime(testLifetime)
+		}},
+		{"file", func(dir string) Store {
+			return NewFileCache("kola", "").WithCacheDirectory(dir).WithLifetime(testLifetime)
+		}},
+		{"redis", func(string) Store {
+			return NewRedisCache(server.URL(), "kola", "").WithLifetime(testLifetime)
+		}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			checkStore(t, test.store(t.TempDir()))
+		})
+	}
+
+	t.Run("lru", func(t *testing.T) {
+		t.Parallel()
+		checkCache(t, NewLRUCache(0).WithLifetime(testLifetime))
+	})
+}
+
+func TestLRUEviction(t *testing.T) {
+	lru := NewLRUCache(2)
+	for _, key := range []string{"a", "b"} {
+		if err := lru.Put(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = lru.Get("a")
+	if err := lru.Put("c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := lru.Get("b"); data != nil {
+		t.Errorf("least recently used value was not evicted")
+	}
+	if data, _ := lru.Get("a"); data == nil {
+		t.Errorf("recently used value was evicted")
+	}
+}
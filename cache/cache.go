@@ -1,11 +1,16 @@
-// A simple Put/Get wrapper around bbolt [1].
+// A simple Put/Get cache. BoltCache stores values using bbolt [1];
+// FileCache and RedisCache store them in a directory or a Redis server,
+// and LRUCache keeps them in memory.
 //
 // [1]: https://github.com/etcd-io/bbolt
 package cache
 
 import (
+	"crypto/cipher"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -18,30 +23,100 @@ type (
 	Cache interface {
 		Put(key string, value []byte) error
 		Get(key string) ([]byte, error)
+		GetStale(key string) (*Value, error)
+		PutWithVersion(key string, value []byte, version string) error
+		PutMany(values map[string][]byte) error
+		PutValue(key string, value *Value) error
+		Delete(key string) error
+		Entries() ([]Entry, error)
+	}
+
+	// A Store holds a number of caches (buckets), each belonging to a
+	// single host, and is able to manage them. A Store must be started
+	// before use.
+	Store interface {
+		Start() error
+		Close() error
+
+		// Return the cache for the named bucket, creating it if
+		// necessary.
+		Bucket(cacheName, host string) (Cache, error)
+
+		Buckets() ([]Bucket, error)
+		DeleteBucket(name string) error
+		Prune() (int, error)
+
+		// Describe where values are stored.
+		Location() string
+	}
+
+	// A cached value, along with the time at which it was stored and
+	// the version (e.g. a resourceVersion) reported by the
+	// server, if any.
+	Value struct {
+		Data      []byte
+		Timestamp time.Time
+		Version   string
+	}
+
+	// Information about a single cached value.
+	Entry struct {
+		Key       string
+		Size      int
+		Timestamp time.Time
+		Expired   bool
+	}
+
+	// Information about a bucket. Each bucket holds values for a single
+	// host.
+	Bucket struct {
+		Name    string
+		Host    string
+		Entries []Entry
 	}
 
 	BoltCache struct {
 		cacheDirectory string
 		cacheName      string
+		host           string
 		lifetime       time.Duration
+		openTimeout    time.Duration
+		maxSize        int64
+		maxAge         time.Duration
+		readOnly       bool
+		snapshotPath   string
+		quarantined    string
+		key            []byte
+		aead           cipher.AEAD
 		db             *bolt.DB
 	}
 
+	bucketMetadata struct {
+		Host string `json:"host"`
+
+		// When the bucket was last opened, so that we can evict the
+		// least recently used buckets (see Maintain).
+		LastUsed time.Time `json:"lastUsed"`
+	}
+
 	cacheValue struct {
-		value []byte
-		ts    time.Time
+		value   []byte
+		ts      time.Time
+		version string
 	}
 
 	cacheValueJSON struct {
-		Value []byte
-		Ts    []byte
+		Value   []byte
+		Ts      []byte
+		Version string `json:",omitempty"`
 	}
 )
 
 func (cv cacheValue) MarshalJSON() ([]byte, error) {
 	store := cacheValueJSON{
-		Value: cv.value,
-		Ts:    []byte(cv.ts.Format(time.RFC3339)),
+		Value:   cv.value,
+		Ts:      []byte(cv.ts.Format(time.RFC3339)),
+		Version: cv.version,
 	}
 
 	v, err := json.Marshal(store)
@@ -62,15 +137,84 @@ func (cv *cacheValue) UnmarshalJSON(data []byte) error {
 
 	cv.value = store.Value
 	cv.ts = ts
+	cv.version = store.Version
 
 	return nil
 }
 
+const (
+	// The metadata bucket records information about the other buckets.
+	metadataBucket = "_metadata"
+
+	// How long Start waits for another process to release the database
+	// unless told otherwise with WithOpenTimeout.
+	defaultOpenTimeout = 2 * time.Second
+
+	// How many times openSnapshot tries to copy the database while
+	// another process is writing to it.
+	snapshotAttempts = 3
+)
+
+// Returned by operations that would modify a cache that was opened
+// read-only.
+var ErrReadOnly = errors.New("cache is in use by another process and is read-only")
+
+// Encode a value for storage.
+func encodeValue(value []byte, ts time.Time, version string) ([]byte, error) {
+	return json.Marshal(cacheValue{
+		value:   value,
+		ts:      ts,
+		version: version,
+	})
+}
+
+// Decode a stored value.
+func decodeValue(data []byte) (*Value, error) {
+	var cv cacheValue
+	if err := json.Unmarshal(data, &cv); err != nil {
+		return nil, err
+	}
+
+	return &Value{
+		Data:      cv.value,
+		Timestamp: cv.ts,
+		Version:   cv.version,
+	}, nil
+}
+
+// Return true if a value stored at ts has outlived lifetime. Values never
+// expire if lifetime is zero.
+func expired(lifetime time.Duration, ts time.Time) bool {
+	return lifetime > 0 && time.Since(ts) > lifetime
+}
+
+// Describe a stored value.
+func newEntry(key string, data []byte, lifetime time.Duration) Entry {
+	entry := Entry{
+		Key:  key,
+		Size: len(data),
+	}
+
+	// An entry we can't decode is as good as expired.
+	if value, err := decodeValue(data); err != nil {
+		entry.Expired = true
+	} else {
+		entry.Timestamp = value.Timestamp
+		entry.Expired = expired(lifetime, value.Timestamp)
+	}
+
+	return entry
+}
+
+// Create a new BoltCache that stores values in the named bucket. A cache
+// with an empty name may be used to manage the database (see Buckets,
+// DeleteBucket and Prune) but not to store values.
 func NewCache(appName, cacheName string) *BoltCache {
 	cacheDirectory := filepath.Join(xdg.CacheHome, appName)
 	return &BoltCache{
 		cacheDirectory: cacheDirectory,
 		cacheName:      cacheName,
+		openTimeout:    defaultOpenTimeout,
 	}
 }
 
@@ -84,23 +228,300 @@ func (cache *BoltCache) WithCacheDirectory(dir string) *BoltCache {
 	return cache
 }
 
+// Record the host to which the cached values belong.
+func (cache *BoltCache) WithHost(host string) *BoltCache {
+	cache.host = host
+	return cache
+}
+
+// Set how long Start waits for another process to release its lock on
+// the database. A timeout of zero means wait forever.
+func (cache *BoltCache) WithOpenTimeout(timeout time.Duration) *BoltCache {
+	cache.openTimeout = timeout
+	return cache
+}
+
+// Return true if the database was in use by another process when the
+// cache was started, in which case we are reading from a copy of the
+// database and changes are discarded.
+func (cache *BoltCache) ReadOnly() bool {
+	return cache.readOnly
+}
+
+// Return the path to the cache database.
+func (cache *BoltCache) Path() string {
+	return filepath.Join(cache.cacheDirectory, "cache.db")
+}
+
 func (cache *BoltCache) Start() error {
 	err := ensureDir(cache.cacheDirectory, 0755)
 	if err != nil {
 		return err
 	}
 
-	db, err := bolt.Open(filepath.Join(cache.cacheDirectory, "cache.db"), 0600, nil)
+	err = cache.open()
+	if errors.Is(err, bolt.ErrTimeout) {
+		return cache.openSnapshot()
+	}
+
+	// A cache is only a cache: rather than failing every time we
+	// start, we set an unreadable database aside and start afresh.
+	if errors.Is(err, ErrCorrupt) {
+		if err := cache.quarantine(); err != nil {
+			return err
+		}
+		err = cache.open()
+	}
 	if err != nil {
 		return err
 	}
+
+	if cache.cacheName == "" {
+		return nil
+	}
+
+	return cache.createBucket()
+}
+
+// Open the database, bring it up to date, and apply the size and age
+// limits if they are due.
+func (cache *BoltCache) open() error {
+	db, err := bolt.Open(cache.Path(), 0600, &bolt.Options{Timeout: cache.openTimeout})
+	if err != nil {
+		if isCorrupt(err) {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return err
+	}
 	cache.db = db
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(cache.cacheName))
+	if err := cache.migrate(); err != nil {
+		cache.closeDB()
 		return err
+	}
+
+	if err := cache.checkKey(); err != nil {
+		cache.closeDB()
+		return err
+	}
+
+	if cache.maintenanceDue() {
+		// The limits are applied on a best-effort basis; failing to
+		// apply them shouldn't stop us from using the cache, unless
+		// the database is corrupt or we were unable to reopen it
+		// after compacting it.
+		_, err := cache.Maintain()
+		if errors.Is(err, ErrCorrupt) || cache.db == nil {
+			cache.closeDB()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cache *BoltCache) closeDB() {
+	if cache.db != nil {
+		cache.db.Close()
+		cache.db = nil
+	}
+}
+
+// Another process holds the lock on the database. Bolt takes a shared
+// lock even when opening a database read-only, which would also wait
+// for the writer, so instead we open a copy of the database in the
+// temporary directory.
+//
+// This has some limits. The whole database is copied, so starting is
+// slower for a large cache (see WithMaxSize). A copy taken while the
+// other process is committing a transaction may be inconsistent, so we
+// retry if the file changes while we copy it, and if it keeps changing
+// we check the last copy before using it. Where the system allows it we
+// remove the copy as soon as it is open, so that it isn't left behind if
+// we are killed; otherwise it is removed by Close.
+func (cache *BoltCache) openSnapshot() error {
+	var path string
+	var changed bool
+	for attempt := 0; attempt < snapshotAttempts; attempt++ {
+		if path != "" {
+			os.Remove(path)
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		var err error
+		path, changed, err = copyFile(cache.Path())
+		if err != nil {
+			return err
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("%w (and unable to read a copy: %v)", ErrReadOnly, err)
+	}
+
+	cache.db = db
+	cache.readOnly = true
+
+	if changed {
+		if err := cache.check(); err != nil {
+			cache.closeDB()
+			os.Remove(path)
+			return fmt.Errorf("%w (and unable to read a copy: %v)", ErrReadOnly, err)
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		cache.snapshotPath = path
+	}
+
+	if _, _, err := cache.checkSchema(); err != nil {
+		cache.Close()
+		return err
+	}
+
+	if err := cache.checkKey(); err != nil {
+		cache.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Copy a file to the temporary directory and return the path to the
+// copy. changed is true if the file was modified while we copied it.
+func copyFile(path string) (copyPath string, changed bool, err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer src.Close()
+
+	before, err := src.Stat()
+	if err != nil {
+		return "", false, err
+	}
+
+	dst, err := os.CreateTemp("", "kola-cache-*.db")
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", false, err
+	}
+
+	after, err := src.Stat()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", false, err
+	}
+
+	changed = after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime())
+
+	return dst.Name(), changed, nil
+}
+
+// Create our bucket (if necessary) and record its metadata.
+func (cache *BoltCache) createBucket() error {
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(cache.cacheName)); err != nil {
+			return err
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(bucketMetadata{
+			Host:     cache.host,
+			LastUsed: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		key := "bucket/" + cache.cacheName
+		if data, err = cache.seal(metadataBucket, key, data); err != nil {
+			return err
+		}
+
+		return meta.Put([]byte(key), data)
 	})
-	return err
+}
+
+// Return the metadata for the named bucket.
+func (cache *BoltCache) readBucketMetadata(meta *bolt.Bucket, name string) bucketMetadata {
+	var md bucketMetadata
+
+	if meta == nil {
+		return md
+	}
+
+	key := "bucket/" + name
+	data := meta.Get([]byte(key))
+	if data == nil {
+		return md
+	}
+
+	if data, err := cache.unseal(metadataBucket, key, data); err == nil {
+		//nolint:errcheck
+		json.Unmarshal(data, &md)
+	}
+
+	return md
+}
+
+// Return a new BoltCache that shares the database of an already started
+// cache but stores values in a different bucket. Bolt holds an exclusive
+// lock on the database file, so this is the only way to use more than one
+// bucket at a time.
+func (cache *BoltCache) WithBucket(cacheName, host string) (*BoltCache, error) {
+	if cache.db == nil {
+		return nil, errors.New("cache has not been started")
+	}
+
+	newCache := &BoltCache{
+		cacheDirectory: cache.cacheDirectory,
+		cacheName:      cacheName,
+		host:           host,
+		lifetime:       cache.lifetime,
+		openTimeout:    cache.openTimeout,
+		readOnly:       cache.readOnly,
+		key:            cache.key,
+		aead:           cache.aead,
+		db:             cache.db,
+	}
+
+	// A read-only cache has the buckets it has.
+	if newCache.readOnly {
+		return newCache, nil
+	}
+
+	if err := newCache.createBucket(); err != nil {
+		return nil, err
+	}
+
+	return newCache, nil
+}
+
+// Return the cache for the named bucket.
+func (cache *BoltCache) Bucket(cacheName, host string) (Cache, error) {
+	return cache.WithBucket(cacheName, host)
+}
+
+func (cache *BoltCache) Location() string {
+	return cache.Path()
 }
 
 func (cache *BoltCache) Get(key string) ([]byte, error) {
@@ -111,8 +532,9 @@ func (cache *BoltCache) Get(key string) ([]byte, error) {
 	// always returns successfully.
 	//nolint:errcheck
 	cache.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(cache.cacheName))
-		data = b.Get([]byte(key))
+		if b := tx.Bucket([]byte(cache.cacheName)); b != nil {
+			data = b.Get([]byte(key))
+		}
 		return nil
 	})
 
@@ -120,6 +542,11 @@ func (cache *BoltCache) Get(key string) ([]byte, error) {
 		return nil, nil
 	}
 
+	data, err := cache.unseal(cache.cacheName, key, data)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := json.Unmarshal(data, &cv); err != nil {
 		return nil, err
 	}
@@ -132,15 +559,67 @@ func (cache *BoltCache) Get(key string) ([]byte, error) {
 	return cv.value, nil
 }
 
-func (cache *BoltCache) Put(key string, value []byte) error {
-	data, err := json.Marshal(cacheValue{
-		value: value,
-		ts:    time.Now(),
+// Return the value for key regardless of age, or nil if there is no
+// value.
+func (cache *BoltCache) GetStale(key string) (*Value, error) {
+	var data []byte
+	var cv cacheValue
+
+	//nolint:errcheck
+	cache.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(cache.cacheName)); b != nil {
+			data = b.Get([]byte(key))
+		}
+		return nil
 	})
+
+	if data == nil {
+		return nil, nil
+	}
+
+	data, err := cache.unseal(cache.cacheName, key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cv); err != nil {
+		return nil, err
+	}
+
+	return &Value{
+		Data:      cv.value,
+		Timestamp: cv.ts,
+		Version:   cv.version,
+	}, nil
+}
+
+func (cache *BoltCache) Put(key string, value []byte) error {
+	return cache.PutWithVersion(key, value, "")
+}
+
+// Store a value along with the version reported by the server.
+func (cache *BoltCache) PutWithVersion(key string, value []byte, version string) error {
+	return cache.PutValue(key, &Value{Data: value, Timestamp: time.Now(), Version: version})
+}
+
+// Store a value with the given timestamp and version (for example, a value
+// read from another cache).
+func (cache *BoltCache) PutValue(key string, value *Value) error {
+	// Writes to a read-only cache are quietly discarded, so that
+	// commands work as they would without a cache.
+	if cache.readOnly {
+		return nil
+	}
+
+	data, err := encodeValue(value.Data, value.Timestamp, value.Version)
 	if err != nil {
 		return err
 	}
 
+	if data, err = cache.seal(cache.cacheName, key, data); err != nil {
+		return err
+	}
+
 	err = cache.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(cache.cacheName))
 		err := b.Put([]byte(key), data)
@@ -150,6 +629,185 @@ func (cache *BoltCache) Put(key string, value []byte) error {
 	return err
 }
 
+// Store several values in a single transaction.
+func (cache *BoltCache) PutMany(values map[string][]byte) error {
+	if cache.readOnly {
+		return nil
+	}
+
+	now := time.Now()
+
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cache.cacheName))
+		for key, value := range values {
+			data, err := json.Marshal(cacheValue{
+				value: value,
+				ts:    now,
+			})
+			if err != nil {
+				return err
+			}
+
+			if data, err = cache.seal(cache.cacheName, key, data); err != nil {
+				return err
+			}
+
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (cache *BoltCache) Delete(key string) error {
+	if cache.readOnly {
+		return nil
+	}
+
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cache.cacheName))
+		return b.Delete([]byte(key))
+	})
+}
+
+// Return information about each value in the cache.
+func (cache *BoltCache) Entries() ([]Entry, error) {
+	var entries []Entry
+
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cache.cacheName))
+		if b == nil {
+			return nil
+		}
+
+		entries = cache.bucketEntries(cache.cacheName, b)
+		return nil
+	})
+
+	return entries, err
+}
+
+func (cache *BoltCache) bucketEntries(name string, b *bolt.Bucket) []Entry {
+	var entries []Entry
+
+	//nolint:errcheck
+	b.ForEach(func(k, v []byte) error {
+		// A value we can't decrypt is reported (by newEntry) as
+		// expired.
+		if data, err := cache.unseal(name, string(k), v); err == nil {
+			v = data
+		}
+
+		entries = append(entries, newEntry(string(k), v, cache.lifetime))
+		return nil
+	})
+
+	return entries
+}
+
+// Return information about every bucket in the database.
+func (cache *BoltCache) Buckets() ([]Bucket, error) {
+	var buckets []Bucket
+
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metadataBucket))
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if string(name) == metadataBucket {
+				return nil
+			}
+
+			bucket := Bucket{
+				Name:    string(name),
+				Entries: cache.bucketEntries(string(name), b),
+				Host:    cache.readBucketMetadata(meta, string(name)).Host,
+			}
+
+			buckets = append(buckets, bucket)
+			return nil
+		})
+	})
+
+	return buckets, err
+}
+
+// Delete a bucket and everything in it.
+func (cache *BoltCache) DeleteBucket(name string) error {
+	if name == metadataBucket {
+		return fmt.Errorf("%s is not a cache bucket", name)
+	}
+
+	if cache.readOnly {
+		return ErrReadOnly
+	}
+
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		return deleteBucket(tx, name)
+	})
+}
+
+// Delete a bucket and its metadata.
+func deleteBucket(tx *bolt.Tx, name string) error {
+	if err := tx.DeleteBucket([]byte(name)); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if meta := tx.Bucket([]byte(metadataBucket)); meta != nil {
+		return meta.Delete([]byte("bucket/" + name))
+	}
+
+	return nil
+}
+
+// Delete expired values from every bucket in the database and return the
+// number of values deleted.
+func (cache *BoltCache) Prune() (int, error) {
+	if cache.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	pruned := 0
+
+	err := cache.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if string(name) == metadataBucket {
+				return nil
+			}
+
+			for _, entry := range cache.bucketEntries(string(name), b) {
+				if !entry.Expired {
+					continue
+				}
+				if err := b.Delete([]byte(entry.Key)); err != nil {
+					return err
+				}
+				pruned++
+			}
+
+			return nil
+		})
+	})
+
+	return pruned, err
+}
+
+// Close the database (and discard our copy of it, if we made one and
+// couldn't remove it when we opened it).
+func (cache *BoltCache) Close() error {
+	if cache.db == nil {
+		return nil
+	}
+
+	err := cache.db.Close()
+	if cache.snapshotPath != "" {
+		os.Remove(cache.snapshotPath)
+	}
+
+	return err
+}
+
 // via https://stackoverflow.com/a/56600630/147356
 func ensureDir(dirName string, mode os.FileMode) error {
 	err := os.Mkdir(dirName, mode)
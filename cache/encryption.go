@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/adrg/xdg"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Encrypted values start with this prefix, followed by the nonce
+	// and the sealed value. Unencrypted values are JSON objects, so
+	// the two can't be confused.
+	encryptedPrefix = "kola:aes-gcm:"
+
+	// Where we keep a value encrypted with the cache key, in the
+	// metadata bucket, so that we can tell whether we were given the
+	// right key.
+	encryptionKey = "encryption"
+
+	// The value we encrypt to check the key.
+	encryptionCheck = "kola"
+
+	// Keys shorter than this are rejected.
+	minKeyLength = 16
+)
+
+var (
+	// Returned when the database is encrypted but we have no key.
+	ErrKeyRequired = errors.New("cache is encrypted and no key was given")
+
+	// Returned when the database was encrypted with a different key.
+	ErrWrongKey = errors.New("cache was encrypted with a different key")
+)
+
+// Return the path to the keyring file used by LoadKey.
+func KeyringPath() string {
+	return filepath.Join(xdg.DataHome, "kola", "keyring")
+}
+
+// Load an encryption key from source, which is one of:
+//
+//   - env:NAME, the value of the environment variable NAME
+//   - file:PATH, the contents of the file PATH
+//   - keyring:NAME, the key called NAME in the keyring file (see
+//     KeyringPath), which holds one "NAME KEY" pair per line
+//
+// Files must not be readable by other users.
+func LoadKey(source string) ([]byte, error) {
+	kind, location, _ := strings.Cut(source, ":")
+
+	var key string
+	switch kind {
+	case "env":
+		key = os.Getenv(location)
+		if key == "" {
+			return nil, fmt.Errorf("no cache key in environment variable %s", location)
+		}
+	case "file":
+		data, err := readPrivateFile(location)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	case "keyring":
+		var err error
+		if key, err = keyringLookup(KeyringPath(), location); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid cache key source %q (expected env:NAME, file:PATH or keyring:NAME)", source)
+	}
+
+	key = strings.TrimSpace(key)
+	if len(key) < minKeyLength {
+		return nil, fmt.Errorf("cache key from %s is too short (use at least %d characters)", source, minKeyLength)
+	}
+
+	return []byte(key), nil
+}
+
+// Read a file that holds a secret, refusing to do so if other users can
+// read it.
+func readPrivateFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Windows doesn't have Unix permissions.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("permissions %#o for %s are too open; it must not be accessible by other users", info.Mode().Perm(), path)
+	}
+
+	return os.ReadFile(path)
+}
+
+func keyringLookup(path, name string) (string, error) {
+	data, err := readPrivateFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			return fields[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("no key named %q in %s", name, path)
+}
+
+// Encrypt values with a key derived from the given key. The key should
+// be long and random; we don't stretch it as we would a password.
+func (cache *BoltCache) WithEncryptionKey(key []byte) *BoltCache {
+	sum := sha256.Sum256(key)
+	cache.key = sum[:]
+	return cache
+}
+
+// Return true if values are encrypted.
+func (cache *BoltCache) Encrypted() bool {
+	return cache.aead != nil
+}
+
+func (cache *BoltCache) newAEAD() error {
+	if cache.key == nil {
+		return nil
+	}
+
+	block, err := aes.NewCipher(cache.key)
+	if err != nil {
+		return err
+	}
+
+	cache.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// Encrypt the value stored under key in the named bucket, if we have an
+// encryption key. The bucket and key are authenticated along with the
+// value, so that encrypted values can't be moved around.
+func (cache *BoltCache) seal(bucket, key string, data []byte) ([]byte, error) {
+	if cache.aead == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, cache.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(encryptedPrefix), nonce...)
+	return cache.aead.Seal(out, nonce, data, []byte(bucket+"/"+key)), nil
+}
+
+// Decrypt a value sealed by seal.
+func (cache *BoltCache) unseal(bucket, key string, data []byte) ([]byte, error) {
+	encrypted := bytes.HasPrefix(data, []byte(encryptedPrefix))
+
+	switch {
+	case cache.aead == nil && encrypted:
+		return nil, ErrKeyRequired
+	case cache.aead == nil:
+		return data, nil
+	case !encrypted:
+		return nil, fmt.Errorf("%s/%s: value is not encrypted", bucket, key)
+	}
+
+	data = data[len(encryptedPrefix):]
+	if len(data) < cache.aead.NonceSize() {
+		return nil, fmt.Errorf("%s/%s: %w: encrypted value is too short", bucket, key, ErrCorrupt)
+	}
+
+	nonce, sealed := data[:cache.aead.NonceSize()], data[cache.aead.NonceSize():]
+	plain, err := cache.aead.Open(nil, nonce, sealed, []byte(bucket+"/"+key))
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", bucket, key, ErrWrongKey)
+	}
+
+	return plain, nil
+}
+
+// Check that we were given the right key for the database (or none if it
+// isn't encrypted). If we were given a key for a database that isn't
+// encrypted yet, encrypt it, unless it is read-only, in which case we
+// read it as it is.
+func (cache *BoltCache) checkKey() error {
+	if err := cache.newAEAD(); err != nil {
+		return err
+	}
+
+	var check []byte
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket([]byte(metadataBucket)); meta != nil {
+			check = append(check, meta.Get([]byte(encryptionKey))...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if check != nil {
+		if cache.aead == nil {
+			return fmt.Errorf("%s: %w", cache.Path(), ErrKeyRequired)
+		}
+
+		plain, err := cache.unseal(metadataBucket, encryptionKey, check)
+		if err != nil || string(plain) != encryptionCheck {
+			return fmt.Errorf("%s: %w", cache.Path(), ErrWrongKey)
+		}
+
+		return nil
+	}
+
+	if cache.aead == nil {
+		return nil
+	}
+
+	if cache.readOnly {
+		cache.aead = nil
+		return nil
+	}
+
+	if err := cache.encryptAll(); err != nil {
+		return err
+	}
+
+	// Bolt writes the encrypted values to new pages and leaves the
+	// unencrypted ones in free pages, which it may not reuse for a long
+	// time. Compacting copies only the pages in use to a new file.
+	return cache.compact()
+}
+
+// Encrypt every value in the database, along with the bucket metadata
+// (which includes host names), and record that the database is encrypted.
+func (cache *BoltCache) encryptAll() error {
+	return cache.db.Update(func(tx *bolt.Tx) error {
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			bucket := string(name)
+
+			// We can't modify a bucket while iterating over it.
+			values := make(map[string][]byte)
+			err := b.ForEach(func(k, v []byte) error {
+				if bucket == metadataBucket && !strings.HasPrefix(string(k), "bucket/") {
+					return nil
+				}
+				sealed, err := cache.seal(bucket, string(k), v)
+				values[string(k)] = sealed
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, v := range values {
+				if err := b.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+		if err != nil {
+			return err
+		}
+
+		check, err := cache.seal(metadataBucket, encryptionKey, []byte(encryptionCheck))
+		if err != nil {
+			return err
+		}
+
+		return meta.Put([]byte(encryptionKey), check)
+	})
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+const (
+	secretHost  = "https://api.secret-cluster.example.com:6443"
+	secretValue = "a package manifest nobody else should read"
+)
+
+var (
+	testKey  = []byte("correct horse battery staple")
+	wrongKey = []byte("incorrect horse battery staple")
+)
+
+// Start a store and return the value of "secret" in its bucket.
+func readSecret(store *BoltCache) (string, error) {
+	if err := store.Start(); err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	c, err := store.Bucket("bucket", secretHost)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := c.Get("secret")
+	return string(data), err
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+
+	// Start with an unencrypted cache, which should be encrypted when
+	// we first open it with a key.
+	store := newStore(dir)
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.Bucket("bucket", secretHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("secret", []byte(secretValue)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough values that their unencrypted copies end up in pages
+	// that bolt frees, rather than reuses, when we encrypt them.
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		values[fmt.Sprintf("key%03d", i)] = []byte(strings.Repeat(secretValue, 10))
+	}
+	if err := c.PutMany(values); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = newStore(dir).WithEncryptionKey(testKey)
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Encrypted() {
+		t.Errorf("cache is not encrypted")
+	}
+
+	c, err = store.Bucket("bucket", secretHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutMany(map[string][]byte{"other": []byte(secretValue)}); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := store.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Host != secretHost || len(buckets[0].Entries) != 102 || buckets[0].Entries[0].Expired {
+		t.Errorf("unexpected buckets %+v", buckets)
+	}
+	store.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-cluster")) {
+		t.Errorf("host name stored in the clear")
+	}
+	if bytes.Contains(data, []byte("nobody else")) {
+		t.Errorf("value stored in the clear")
+	}
+
+	if value, err := readSecret(newStore(dir).WithEncryptionKey(testKey)); err != nil || value != secretValue {
+		t.Errorf("right key: %q, %v", value, err)
+	}
+
+	if _, err := readSecret(newStore(dir)); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("no key: %v", err)
+	}
+
+	if _, err := readSecret(newStore(dir).WithEncryptionKey(wrongKey)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong key: %v", err)
+	}
+
+	// While another process (or, here, another store) holds the lock
+	// we read a copy of the database, which must also be decrypted.
+	startStore(t, newStore(dir).WithEncryptionKey(testKey))
+
+	reader := newStore(dir).WithEncryptionKey(testKey).WithOpenTimeout(100 * time.Millisecond)
+	value, err := readSecret(reader)
+	if err != nil || value != secretValue || !reader.ReadOnly() {
+		t.Errorf("read-only copy: %q, %v", value, err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, append(testKey, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := LoadKey("file:" + keyFile); err != nil || !bytes.Equal(loaded, testKey) {
+		t.Errorf("file: %q, %v", loaded, err)
+	}
+
+	if err := os.Chmod(keyFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey("file:" + keyFile); err == nil || !strings.Contains(err.Error(), "too open") {
+		t.Errorf("readable key file: %v", err)
+	}
+
+	t.Setenv("KOLA_TEST_KEY", string(testKey))
+	if loaded, err := LoadKey("env:KOLA_TEST_KEY"); err != nil || !bytes.Equal(loaded, testKey) {
+		t.Errorf("env: %q, %v", loaded, err)
+	}
+
+	if _, err := LoadKey("env:KOLA_TEST_MISSING"); err == nil {
+		t.Errorf("missing environment variable was accepted")
+	}
+
+	t.Setenv("KOLA_TEST_KEY", "short")
+	if _, err := LoadKey("env:KOLA_TEST_KEY"); err == nil || !strings.Contains(err.Error(), "too short") {
+		t.Errorf("short key: %v", err)
+	}
+
+	dataHome := xdg.DataHome
+	xdg.DataHome = dir
+	t.Cleanup(func() { xdg.DataHome = dataHome })
+
+	keyring := KeyringPath()
+	if err := os.MkdirAll(filepath.Dir(keyring), 0700); err != nil {
+		t.Fatal(err)
+	}
+	contents := fmt.Sprintf("# test keys\nother %s\nlaptop %s\n", wrongKey, strings.ReplaceAll(string(testKey), " ", "-"))
+	if err := os.WriteFile(keyring, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := LoadKey("keyring:laptop"); err != nil || string(loaded) != "correct-horse-battery-staple" {
+		t.Errorf("keyring: %q, %v", loaded, err)
+	}
+
+	if _, err := LoadKey("keyring:missing"); err == nil {
+		t.Errorf("missing keyring entry was accepted")
+	}
+
+	if _, err := LoadKey("password:hunter2"); err == nil {
+		t.Errorf("invalid key source was accepted")
+	}
+}
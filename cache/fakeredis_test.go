@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// A fake Redis server that implements just enough of the protocol
+	// for RedisCache: PING, AUTH, SELECT, GET, SET, MGET, MSET, DEL and
+	// SCAN. All keys live in a single map, and SCAN returns everything
+	// in one batch.
+	fakeRedis struct {
+		mu       sync.Mutex
+		password string
+		data     map[string][]byte
+		listener net.Listener
+	}
+)
+
+func newFakeRedis(password string) (*fakeRedis, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &fakeRedis{
+		password: password,
+		data:     make(map[string][]byte),
+		listener: listener,
+	}
+
+	go server.serve()
+	return server, nil
+}
+
+func (server *fakeRedis) URL() string {
+	if server.password != "" {
+		return fmt.Sprintf("redis://:%s@%s/2", server.password, server.listener.Addr())
+	}
+	return fmt.Sprintf("redis://%s", server.listener.Addr())
+}
+
+func (server *fakeRedis) Close() error {
+	return server.listener.Close()
+}
+
+func (server *fakeRedis) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authenticated := server.password == ""
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(string(args[0]))
+		if !authenticated && cmd != "AUTH" {
+			fmt.Fprintf(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		switch cmd {
+		case "AUTH":
+			if string(args[len(args)-1]) != server.password {
+				fmt.Fprintf(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authenticated = true
+			fmt.Fprintf(conn, "+OK\r\n")
+		default:
+			conn.Write(server.execute(cmd, args[1:]))
+		}
+	}
+}
+
+func (server *fakeRedis) execute(cmd string, args [][]byte) []byte {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	switch cmd {
+	case "PING":
+		return []byte("+PONG\r\n")
+	case "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		return bulk(server.data[string(args[0])])
+	case "SET":
+		server.data[string(args[0])] = args[1]
+		return []byte("+OK\r\n")
+	case "MSET":
+		for i := 0; i+1 < len(args); i += 2 {
+			server.data[string(args[i])] = args[i+1]
+		}
+		return []byte("+OK\r\n")
+	case "MGET":
+		reply := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+		for _, key := range args {
+			reply = append(reply, bulk(server.data[string(key)])...)
+		}
+		return reply
+	case "DEL":
+		deleted := 0
+		for _, key := range args {
+			if _, ok := server.data[string(key)]; ok {
+				delete(server.data, string(key))
+				deleted++
+			}
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", deleted))
+	case "SCAN":
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(string(args[i])) == "MATCH" {
+				pattern = string(args[i+1])
+			}
+		}
+		re := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+
+		var keys []string
+		for key := range server.data {
+			if re.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		reply := []byte(fmt.Sprintf("*2\r\n%s*%d\r\n", bulk([]byte("0")), len(keys)))
+		for _, key := range keys {
+			reply = append(reply, bulk([]byte(key))...)
+		}
+		return reply
+	}
+
+	return []byte(fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd))
+}
+
+func bulk(data []byte) []byte {
+	if data == nil {
+		return []byte("$-1\r\n")
+	}
+	return append([]byte(fmt.Sprintf("$%d\r\n", len(data))), append(data, "\r\n"...)...)
+}
+
+// Read a command sent as an array of bulk strings.
+func readCommand(reader *bufio.Reader) ([][]byte, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("bad command length %q", line)
+	}
+
+	args := make([][]byte, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = data[:size]
+	}
+
+	return args, nil
+}
@@ -0,0 +1,355 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+type (
+	// A FileCache stores each value in its own file, in one directory
+	// per bucket, so that the cache is easy to inspect or to sync
+	// between machines. Files contain the same JSON envelope as the
+	// values in a BoltCache.
+	FileCache struct {
+		directory string
+		cacheName string
+		host      string
+		lifetime  time.Duration
+	}
+)
+
+// Create a new FileCache that stores values in the named bucket. As with
+// NewCache, a cache with an empty name may only be used to manage the
+// cache directory.
+func NewFileCache(appName, cacheName string) *FileCache {
+	return &FileCache{
+		directory: filepath.Join(xdg.CacheHome, appName, "files"),
+		cacheName: cacheName,
+	}
+}
+
+func (cache *FileCache) WithLifetime(lifetime time.Duration) *FileCache {
+	cache.lifetime = lifetime
+	return cache
+}
+
+func (cache *FileCache) WithCacheDirectory(dir string) *FileCache {
+	cache.directory = dir
+	return cache
+}
+
+// Record the host to which the cached values belong.
+func (cache *FileCache) WithHost(host string) *FileCache {
+	cache.host = host
+	return cache
+}
+
+func (cache *FileCache) Location() string {
+	return cache.directory
+}
+
+func (cache *FileCache) Start() error {
+	if err := os.MkdirAll(cache.directory, 0755); err != nil {
+		return err
+	}
+
+	if cache.cacheName == "" {
+		return nil
+	}
+
+	return cache.createBucket()
+}
+
+// There is nothing to close.
+func (cache *FileCache) Close() error {
+	return nil
+}
+
+// Create our bucket directory (if necessary) and record its metadata.
+func (cache *FileCache) createBucket() error {
+	if err := os.MkdirAll(filepath.Join(cache.directory, cache.cacheName), 0755); err != nil {
+		return err
+	}
+
+	if cache.host == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(cache.directory, metadataBucket), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(bucketMetadata{Host: cache.host})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cache.metadataPath(cache.cacheName), data)
+}
+
+// Return the cache for the named bucket.
+func (cache *FileCache) Bucket(cacheName, host string) (Cache, error) {
+	newCache := &FileCache{
+		directory: cache.directory,
+		cacheName: cacheName,
+		host:      host,
+		lifetime:  cache.lifetime,
+	}
+
+	if err := newCache.createBucket(); err != nil {
+		return nil, err
+	}
+
+	return newCache, nil
+}
+
+func (cache *FileCache) metadataPath(name string) string {
+	return filepath.Join(cache.directory, metadataBucket, name+".json")
+}
+
+// Keys may contain slashes, so we escape them to produce file names. We
+// also escape a leading dot so that no key can collide with our
+// temporary files.
+func keyToFileName(key string) string {
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+
+	return name
+}
+
+func fileNameToKey(name string) (string, error) {
+	return url.PathUnescape(name)
+}
+
+func (cache *FileCache) path(key string) string {
+	return filepath.Join(cache.directory, cache.cacheName, keyToFileName(key))
+}
+
+// Write a file by writing a temporary file and renaming it, so that
+// readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (cache *FileCache) Get(key string) ([]byte, error) {
+	value, err := cache.GetStale(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	// Return nil if cache value has expired.
+	if expired(cache.lifetime, value.Timestamp) {
+		return nil, nil
+	}
+
+	return value.Data, nil
+}
+
+// Return the value for key regardless of age, or nil if there is no
+// value.
+func (cache *FileCache) GetStale(key string) (*Value, error) {
+	data, err := os.ReadFile(cache.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return decodeValue(data)
+}
+
+func (cache *FileCache) Put(key string, value []byte) error {
+	return cache.PutWithVersion(key, value, "")
+}
+
+// Store a value along with the version reported by the server.
+func (cache *FileCache) PutWithVersion(key string, value []byte, version string) error {
+	return cache.PutValue(key, &Value{Data: value, Timestamp: time.Now(), Version: version})
+}
+
+// Store a value with the given timestamp and version.
+func (cache *FileCache) PutValue(key string, value *Value) error {
+	data, err := encodeValue(value.Data, value.Timestamp, value.Version)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cache.path(key), data)
+}
+
+// Store several values.
+func (cache *FileCache) PutMany(values map[string][]byte) error {
+	now := time.Now()
+	for key, value := range values {
+		data, err := encodeValue(value, now, "")
+		if err != nil {
+			return err
+		}
+
+		if err := writeFileAtomic(cache.path(key), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cache *FileCache) Delete(key string) error {
+	err := os.Remove(cache.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Return information about each value in the cache.
+func (cache *FileCache) Entries() ([]Entry, error) {
+	return cache.bucketEntries(cache.cacheName)
+}
+
+func (cache *FileCache) bucketEntries(name string) ([]Entry, error) {
+	dir := filepath.Join(cache.directory, name)
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		key, err := fileNameToKey(file.Name())
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, newEntry(key, data, cache.lifetime))
+	}
+
+	return entries, nil
+}
+
+// Return information about every bucket in the cache directory.
+func (cache *FileCache) Buckets() ([]Bucket, error) {
+	dirs, err := os.ReadDir(cache.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == metadataBucket {
+			continue
+		}
+
+		entries, err := cache.bucketEntries(dir.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		bucket := Bucket{
+			Name:    dir.Name(),
+			Entries: entries,
+		}
+
+		var md bucketMetadata
+		if data, err := os.ReadFile(cache.metadataPath(bucket.Name)); err == nil {
+			if err := json.Unmarshal(data, &md); err == nil {
+				bucket.Host = md.Host
+			}
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
+
+// Delete a bucket and everything in it.
+func (cache *FileCache) DeleteBucket(name string) error {
+	if name == metadataBucket || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%s is not a cache bucket", name)
+	}
+
+	dir := filepath.Join(cache.directory, name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	err := os.Remove(cache.metadataPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Delete expired values from every bucket and return the number of
+// values deleted.
+func (cache *FileCache) Prune() (int, error) {
+	buckets, err := cache.Buckets()
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, bucket := range buckets {
+		for _, entry := range bucket.Entries {
+			if !entry.Expired {
+				continue
+			}
+
+			path := filepath.Join(cache.directory, bucket.Name, keyToFileName(entry.Key))
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return pruned, err
+			}
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Return a BoltCache for managing the database in dir.
+func newStore(dir string) *BoltCache {
+	return NewCache("kola", "").WithCacheDirectory(dir)
+}
+
+// Start store and close it when the test finishes.
+func startStore(t *testing.T, store *BoltCache) *BoltCache {
+	t.Helper()
+
+	if err := store.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// Return the names of the buckets in the database in dir, in order.
+func bucketNames(t *testing.T, dir string) string {
+	t.Helper()
+
+	store := newStore(dir)
+	if err := store.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer store.Close()
+
+	buckets, err := store.Buckets()
+	if err != nil {
+		t.Fatalf("buckets: %v", err)
+	}
+
+	var names []string
+	for _, bucket := range buckets {
+		names = append(names, bucket.Name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+// Read a key from the metadata bucket of the database in dir.
+func readMetadata(t *testing.T, dir, key string) []byte {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(dir, "cache.db"), 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var data []byte
+	err = db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metadataBucket))
+		if meta == nil {
+			return errors.New("no metadata bucket")
+		}
+		data = append(data, meta.Get([]byte(key))...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// Write a key to the metadata bucket of the database in dir.
+func writeMetadata(t *testing.T, dir, key string, value []byte) {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(dir, "cache.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(key), value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	// What Maintain did.
+	MaintenanceResult struct {
+		// Buckets that were deleted, least recently used first.
+		// Entries are not included.
+		Evicted []Bucket
+
+		// The size of the database file before and after.
+		SizeBefore int64
+		SizeAfter  int64
+
+		Compacted bool
+
+		// Unreadable databases set aside by Start that were deleted.
+		Removed []string
+	}
+
+	bucketUsage struct {
+		name     string
+		host     string
+		size     int64
+		lastUsed time.Time
+	}
+)
+
+const (
+	// Start applies the size and age limits at most this often.
+	maintenanceInterval = time.Hour
+
+	// Where we record when the limits were last applied, in the
+	// metadata bucket.
+	maintenanceKey = "maintenance"
+
+	// We compact the database when less than this fraction of the file
+	// is in use...
+	compactUsedRatio = 0.5
+
+	// ...unless the file is smaller than this.
+	compactMinSize = 1 << 20
+
+	// Copy at most this much data in each transaction when compacting.
+	compactTxMaxSize = 64 << 20
+)
+
+// Limit the size of the database. When the space used by the buckets in
+// the database exceeds size bytes, Maintain deletes the least recently
+// used buckets until it doesn't. A size of zero means no limit.
+func (cache *BoltCache) WithMaxSize(size int64) *BoltCache {
+	cache.maxSize = size
+	return cache
+}
+
+// Delete buckets that have not been used for longer than age. An age of
+// zero means buckets are kept regardless of age.
+func (cache *BoltCache) WithMaxAge(age time.Duration) *BoltCache {
+	cache.maxAge = age
+	return cache
+}
+
+// Return true if Start should apply the size and age limits.
+func (cache *BoltCache) maintenanceDue() bool {
+	if cache.readOnly || (cache.maxSize == 0 && cache.maxAge == 0) {
+		return false
+	}
+
+	var last time.Time
+
+	//nolint:errcheck
+	cache.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket([]byte(metadataBucket)); meta != nil {
+			//nolint:errcheck
+			last.UnmarshalText(meta.Get([]byte(maintenanceKey)))
+		}
+		return nil
+	})
+
+	return time.Since(last) > maintenanceInterval
+}
+
+// Check the database for damage (returning ErrCorrupt if we find any)
+// and apply the size and age limits: delete the buckets that have not
+// been used within the maximum age, then the least recently used buckets
+// until the rest fit within the maximum size, and finally compact the
+// database if much of it is unused. It also deletes unreadable databases
+// that Start set aside more than a week ago. This closes and reopens the
+// database, so it must not be called while caches returned by WithBucket
+// are in use.
+func (cache *BoltCache) Maintain() (*MaintenanceResult, error) {
+	if cache.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if err := cache.check(); err != nil {
+		return nil, err
+	}
+
+	result := &MaintenanceResult{
+		Removed: cache.removeQuarantined(),
+	}
+
+	info, err := os.Stat(cache.Path())
+	if err != nil {
+		return nil, err
+	}
+	result.SizeBefore = info.Size()
+	result.SizeAfter = info.Size()
+
+	usage, err := cache.bucketUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Least recently used first.
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].lastUsed.Before(usage[j].lastUsed)
+	})
+
+	var total int64
+	for _, bucket := range usage {
+		total += bucket.size
+	}
+
+	var evict []bucketUsage
+	for _, bucket := range usage {
+		tooOld := cache.maxAge > 0 && time.Since(bucket.lastUsed) > cache.maxAge
+		tooBig := cache.maxSize > 0 && total > cache.maxSize
+		if !tooOld && !tooBig {
+			break
+		}
+
+		evict = append(evict, bucket)
+		total -= bucket.size
+	}
+
+	now, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cache.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range evict {
+			if err := deleteBucket(tx, bucket.name); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+		if err != nil {
+			return err
+		}
+
+		return meta.Put([]byte(maintenanceKey), now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range evict {
+		result.Evicted = append(result.Evicted, Bucket{Name: bucket.name, Host: bucket.host})
+	}
+
+	// Space is wasted both on free pages and at the end of the file,
+	// since bolt grows the file in large steps, so we compare the space
+	// used by the remaining buckets with the size of the file.
+	if result.SizeBefore < compactMinSize || float64(total) >= float64(result.SizeBefore)*compactUsedRatio {
+		return result, nil
+	}
+
+	if err := cache.compact(); err != nil {
+		return result, err
+	}
+	result.Compacted = true
+
+	if info, err := os.Stat(cache.Path()); err == nil {
+		result.SizeAfter = info.Size()
+	}
+
+	return result, nil
+}
+
+// Return the space used by each bucket and when it was last used.
+func (cache *BoltCache) bucketUsage() ([]bucketUsage, error) {
+	var usage []bucketUsage
+
+	err := cache.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metadataBucket))
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if string(name) == metadataBucket {
+				return nil
+			}
+
+			stats := b.Stats()
+			bucket := bucketUsage{
+				name: string(name),
+				size: int64(stats.BranchAlloc + stats.LeafAlloc + stats.InlineBucketInuse),
+			}
+
+			md := cache.readBucketMetadata(meta, bucket.name)
+			bucket.host = md.Host
+			bucket.lastUsed = md.LastUsed
+
+			usage = append(usage, bucket)
+			return nil
+		})
+	})
+
+	return usage, err
+}
+
+// Bolt never shrinks its database file; it reuses free pages instead. To
+// reclaim the space we copy the database to a new file and replace the
+// old one. A process that was waiting for the lock on the old file will
+// use (and write to) the old file once we close it, so its changes are
+// lost; for a cache, that's harmless.
+func (cache *BoltCache) compact() error {
+	path := cache.Path()
+	tmpPath := path + ".compact"
+
+	// We hold the lock on the database, so anything already at
+	// tmpPath was left behind by a process that didn't finish.
+	os.Remove(tmpPath)
+
+	dst, err := bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: cache.openTimeout})
+	if err != nil {
+		return err
+	}
+
+	if err := bolt.Compact(dst, cache.db, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := cache.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	cache.db = nil
+
+	renameErr := os.Rename(tmpPath, path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+
+	// Reopen the database whether or not we replaced it.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: cache.openTimeout})
+	if err != nil {
+		return err
+	}
+	cache.db = db
+
+	if renameErr != nil {
+		return fmt.Errorf("unable to replace the cache with a compacted copy: %w", renameErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	// Each bucket filled by fillBuckets holds about this much data.
+	testBucketSize = 2 << 20
+	testValueSize  = 64 << 10
+)
+
+// Open each named bucket in turn (so that the last is the most recently
+// used) and fill it with data.
+func fillBuckets(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	store := newStore(dir)
+	if err := store.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer store.Close()
+
+	value := bytes.Repeat([]byte("x"), testValueSize)
+	for _, name := range names {
+		c, err := store.Bucket(name, "https://"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := make(map[string][]byte)
+		for i := 0; i < testBucketSize/testValueSize; i++ {
+			values[fmt.Sprintf("key%d", i)] = value
+		}
+
+		if err := c.PutMany(values); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Open each named bucket without changing it.
+func touchBuckets(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	store := newStore(dir)
+	if err := store.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer store.Close()
+
+	for _, name := range names {
+		if _, err := store.Bucket(name, "https://"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dbFileSize(t *testing.T, dir string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(filepath.Join(dir, "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
+
+func TestMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	fillBuckets(t, dir, "a", "b", "c", "d")
+	// Use a again, so that b is the least recently used.
+	touchBuckets(t, dir, "a")
+
+	before := dbFileSize(t, dir)
+
+	// Applying the limits on Start should evict b and c. Values are
+	// base64 encoded and stored in whole pages, so each bucket takes
+	// up rather more than testBucketSize.
+	store := newStore(dir).WithMaxSize(7 << 20)
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if names := bucketNames(t, dir); names != "a,d" {
+		t.Errorf("expected buckets a,d, found %s", names)
+	}
+
+	if after := dbFileSize(t, dir); after >= before*3/4 {
+		t.Errorf("database was not compacted (%d bytes before, %d after)", before, after)
+	}
+
+	// The database must still be usable.
+	fillBuckets(t, dir, "e")
+	if names := bucketNames(t, dir); names != "a,d,e" {
+		t.Errorf("expected buckets a,d,e after compaction, found %s", names)
+	}
+
+	// The limits were applied recently, so Start doesn't apply them
+	// again, but Maintain does, evicting d (a was used after it).
+	store = startStore(t, newStore(dir).WithMaxSize(7<<20))
+
+	buckets, err := store.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 {
+		t.Errorf("Start applied the limits twice in a row")
+	}
+
+	result, err := store.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Evicted) != 1 || result.Evicted[0].Name != "d" || result.Evicted[0].Host != "https://d" {
+		t.Errorf("Maintain evicted %+v, expected d", result.Evicted)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	dir := t.TempDir()
+
+	fillBuckets(t, dir, "a", "b")
+	time.Sleep(2 * time.Second)
+	touchBuckets(t, dir, "b")
+
+	store := newStore(dir).WithMaxAge(time.Second)
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if names := bucketNames(t, dir); names != "b" {
+		t.Errorf("expected bucket b, found %s", names)
+	}
+}
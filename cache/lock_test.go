@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	lockBucket = "locktest"
+	lockHost   = "https://example.com"
+
+	// How long readers wait for the lock.
+	lockReadTimeout = 500 * time.Millisecond
+
+	// How long the holder keeps the database open.
+	lockHoldTime = 4 * time.Second
+)
+
+func openLockBucket(dir string, timeout time.Duration) (*BoltCache, Cache, error) {
+	store := newStore(dir).WithOpenTimeout(timeout)
+	if err := store.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	c, err := store.Bucket(lockBucket, lockHost)
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+
+	return store, c, nil
+}
+
+// Run a test binary as a child process that plays the given part in
+// TestLock (see TestLockHelper).
+func lockHelper(dir, part, arg string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelper$")
+	cmd.Env = append(os.Environ(),
+		"KOLA_LOCK_PART="+part,
+		"KOLA_LOCK_DIR="+dir,
+		"KOLA_LOCK_ARG="+arg)
+	return cmd
+}
+
+// When run by TestLock: "hold" opens the cache, stores a value and keeps
+// the database open for a number of seconds, "read" opens the cache with
+// a short timeout and prints what it finds, and "write" opens the cache
+// (waiting as long as necessary) and stores a value.
+func TestLockHelper(t *testing.T) {
+	dir, arg := os.Getenv("KOLA_LOCK_DIR"), os.Getenv("KOLA_LOCK_ARG")
+
+	switch os.Getenv("KOLA_LOCK_PART") {
+	case "":
+		t.Skip("run by TestLock")
+	case "hold":
+		seconds, err := strconv.Atoi(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store, c, err := openLockBucket(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if err := c.Put("held", []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Println("ready")
+		time.Sleep(time.Duration(seconds) * time.Second)
+	case "read":
+		store, c, err := openLockBucket(dir, lockReadTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		data, err := c.Get("held")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Writes to a read-only cache are discarded without error.
+		if err := c.Put("reader", []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Printf("readonly=%t value=%s\n", store.ReadOnly(), data)
+	case "write":
+		store, c, err := openLockBucket(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if err := c.Put("writer/"+arg, []byte(arg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Run several processes against one cache directory and check that a
+// process that finds the cache locked gives up waiting after its open
+// timeout and reads a copy of the cache instead of blocking.
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+
+	holder := lockHelper(dir, "hold", strconv.Itoa(int(lockHoldTime/time.Second)))
+	holder.Stderr = os.Stderr
+	stdout, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the holder has the lock.
+	buf := make([]byte, len("ready\n"))
+	if _, err := stdout.Read(buf); err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+	heldAt := time.Now()
+
+	// Start some readers and some writers at once. The readers must
+	// finish while the holder still has the lock; the writers must all
+	// finish once it lets go.
+	var wg sync.WaitGroup
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			out, err := lockHelper(dir, "read", "").CombinedOutput()
+			elapsed := time.Since(start)
+
+			if err != nil {
+				t.Errorf("reader %d: %v: %s", i, err, out)
+			}
+			if !bytes.Contains(out, []byte("readonly=true value=value")) {
+				t.Errorf("reader %d: unexpected output %q", i, out)
+			}
+			if elapsed >= lockHoldTime-time.Second {
+				t.Errorf("reader %d: took %s to open a locked cache", i, elapsed)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if out, err := lockHelper(dir, "write", strconv.Itoa(i)).CombinedOutput(); err != nil {
+				t.Errorf("writer %d: %v: %s", i, err, out)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	if err := holder.Wait(); err != nil {
+		t.Errorf("holder: %v", err)
+	}
+	if time.Since(heldAt) < lockHoldTime-time.Second {
+		t.Errorf("writers did not wait for the holder")
+	}
+
+	// Once everyone is done, the cache must be writable again and
+	// contain what the writers stored but not what the readers did.
+	store, c, err := openLockBucket(dir, lockReadTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if store.ReadOnly() {
+		t.Errorf("cache is read-only after all processes exited")
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	if strings.Join(keys, ",") != "held,writer/0,writer/1,writer/2" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// An LRUCache keeps values in memory. When it holds more than
+	// maxEntries values it discards the least recently used. It is
+	// meant for programs that use kola as a library and want to avoid
+	// repeated requests without writing anything to disk.
+	LRUCache struct {
+		mu         sync.Mutex
+		maxEntries int
+		lifetime   time.Duration
+		entries    map[string]*list.Element
+		order      *list.List
+	}
+
+	lruEntry struct {
+		key   string
+		value Value
+	}
+)
+
+// Create a new LRUCache that holds at most maxEntries values. A
+// maxEntries of zero means there is no limit.
+func NewLRUCache(maxEntries int) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (cache *LRUCache) WithLifetime(lifetime time.Duration) *LRUCache {
+	cache.lifetime = lifetime
+	return cache
+}
+
+// Look up key and mark it as recently used.
+func (cache *LRUCache) lookup(key string) *Value {
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil
+	}
+
+	cache.order.MoveToFront(elem)
+	value := elem.Value.(*lruEntry).value
+	return &value
+}
+
+func (cache *LRUCache) Get(key string) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	value := cache.lookup(key)
+	if value == nil || expired(cache.lifetime, value.Timestamp) {
+		return nil, nil
+	}
+
+	return value.Data, nil
+}
+
+// Return the value for key regardless of age, or nil if there is no
+// value.
+func (cache *LRUCache) GetStale(key string) (*Value, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.lookup(key), nil
+}
+
+func (cache *LRUCache) Put(key string, value []byte) error {
+	return cache.PutWithVersion(key, value, "")
+}
+
+// Store a value along with the version reported by the server.
+func (cache *LRUCache) PutWithVersion(key string, value []byte, version string) error {
+	return cache.PutValue(key, &Value{Data: value, Timestamp: time.Now(), Version: version})
+}
+
+// Store a value with the given timestamp and version.
+func (cache *LRUCache) PutValue(key string, value *Value) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.store(key, *value)
+	return nil
+}
+
+// Store several values at once.
+func (cache *LRUCache) PutMany(values map[string][]byte) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	for key, value := range values {
+		cache.store(key, Value{Data: value, Timestamp: now})
+	}
+
+	return nil
+}
+
+func (cache *LRUCache) store(key string, value Value) {
+	// Copy the data so that callers may reuse their buffers.
+	value.Data = append([]byte(nil), value.Data...)
+
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		cache.order.MoveToFront(elem)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key: key, value: value})
+
+	for cache.maxEntries > 0 && cache.order.Len() > cache.maxEntries {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (cache *LRUCache) Delete(key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.Remove(elem)
+		delete(cache.entries, key)
+	}
+
+	return nil
+}
+
+// Return information about each value in the cache, most recently used
+// first.
+func (cache *LRUCache) Entries() ([]Entry, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var entries []Entry
+	for elem := cache.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*lruEntry)
+		entries = append(entries, Entry{
+			Key:       entry.key,
+			Size:      len(entry.value.Data),
+			Timestamp: entry.value.Timestamp,
+			Expired:   expired(cache.lifetime, entry.value.Timestamp),
+		})
+	}
+
+	return entries, nil
+}
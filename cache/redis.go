@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A minimal client for the Redis serialization protocol (RESP),
+	// supporting just the commands used by RedisCache. A redisClient
+	// is safe for concurrent use; commands are sent one at a time over
+	// a single connection.
+	redisClient struct {
+		mu       sync.Mutex
+		address  string
+		password string
+		username string
+		db       int
+		timeout  time.Duration
+		conn     net.Conn
+		reader   *bufio.Reader
+	}
+
+	// An error reply from the server.
+	redisError string
+)
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// Parse a server URL of the form redis://[[user]:password@]host[:port][/db].
+// A bare host:port is also accepted.
+func newRedisClient(serverURL string) (*redisClient, error) {
+	client := &redisClient{
+		timeout: 10 * time.Second,
+	}
+
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "redis://" + serverURL
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q in %s", u.Scheme, serverURL)
+	}
+
+	client.address = u.Host
+	if u.Port() == "" {
+		client.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		client.username = u.User.Username()
+		client.password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if client.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q in %s", db, serverURL)
+		}
+	}
+
+	return client, nil
+}
+
+// Connect to the server, authenticate and select our database.
+func (client *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", client.address, client.timeout)
+	if err != nil {
+		return err
+	}
+
+	client.conn = conn
+	client.reader = bufio.NewReader(conn)
+
+	if client.password != "" {
+		args := []interface{}{"AUTH", client.password}
+		if client.username != "" {
+			args = []interface{}{"AUTH", client.username, client.password}
+		}
+		if _, err := client.roundTrip(args); err != nil {
+			client.closeConn()
+			return err
+		}
+	}
+
+	if client.db != 0 {
+		if _, err := client.roundTrip([]interface{}{"SELECT", client.db}); err != nil {
+			client.closeConn()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (client *redisClient) closeConn() {
+	if client.conn != nil {
+		client.conn.Close()
+		client.conn = nil
+	}
+}
+
+// Close the connection to the server.
+func (client *redisClient) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.closeConn()
+	return nil
+}
+
+// Send a command and return the reply. Replies are decoded as string
+// (simple strings), int64 (integers), []byte (bulk strings, nil if the
+// value does not exist) or []interface{} (arrays). We connect on first
+// use, and reconnect once if the connection has gone away.
+func (client *redisClient) do(args ...interface{}) (interface{}, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if client.conn == nil {
+			if err := client.connect(); err != nil {
+				return nil, err
+			}
+		}
+
+		reply, err := client.roundTrip(args)
+
+		var replyErr redisError
+		if err != nil && !errors.As(err, &replyErr) {
+			client.closeConn()
+			if attempt == 0 && (errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)) {
+				continue
+			}
+		}
+
+		return reply, err
+	}
+}
+
+func (client *redisClient) roundTrip(args []interface{}) (interface{}, error) {
+	if err := client.conn.SetDeadline(time.Now().Add(client.timeout)); err != nil {
+		return nil, err
+	}
+
+	// Commands are sent as an array of bulk strings.
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		var data []byte
+		switch arg := arg.(type) {
+		case string:
+			data = []byte(arg)
+		case []byte:
+			data = arg
+		case int:
+			data = []byte(strconv.Itoa(arg))
+		default:
+			return nil, fmt.Errorf("unsupported argument type %T", arg)
+		}
+
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(data))...)
+		buf = append(buf, data...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	if _, err := client.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(client.reader)
+}
+
+// Read a single reply.
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Return all keys matching pattern.
+func (client *redisClient) scan(pattern string) ([]string, error) {
+	var keys []string
+
+	cursor := "0"
+	for {
+		reply, err := client.do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return nil, fmt.Errorf("redis: unexpected reply to SCAN")
+		}
+
+		next, _ := items[0].([]byte)
+		batch, _ := items[1].([]interface{})
+		for _, key := range batch {
+			if key, ok := key.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// Return the values of the given keys; missing values are nil.
+func (client *redisClient) mget(keys []string) ([][]byte, error) {
+	var values [][]byte
+
+	// Fetch keys in batches to keep requests to a reasonable size.
+	const batchSize = 500
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		args := []interface{}{"MGET"}
+		for _, key := range keys[start:end] {
+			args = append(args, key)
+		}
+
+		reply, err := client.do(args...)
+		if err != nil {
+			return nil, err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != end-start {
+			return nil, fmt.Errorf("redis: unexpected reply to MGET")
+		}
+
+		for _, item := range items {
+			data, _ := item.([]byte)
+			values = append(values, data)
+		}
+	}
+
+	return values, nil
+}
+
+// Delete the given keys.
+func (client *redisClient) del(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := []interface{}{"DEL"}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	_, err := client.do(args...)
+	return err
+}
@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	// A RedisCache stores values in a Redis server (or anything that
+	// speaks the Redis protocol), so that a team can share a cache.
+	// Values are stored under <app>:<bucket>:<key> using the same JSON
+	// envelope as a BoltCache. We don't use Redis expiry, because we
+	// want to be able to return stale values.
+	RedisCache struct {
+		serverURL string
+		appName   string
+		cacheName string
+		host      string
+		lifetime  time.Duration
+		client    *redisClient
+	}
+)
+
+// Create a new RedisCache that stores values in the named bucket on the
+// given server (see newRedisClient for the URL format). As with
+// NewCache, a cache with an empty name may only be used to manage the
+// cache.
+func NewRedisCache(serverURL, appName, cacheName string) *RedisCache {
+	return &RedisCache{
+		serverURL: serverURL,
+		appName:   appName,
+		cacheName: cacheName,
+	}
+}
+
+func (cache *RedisCache) WithLifetime(lifetime time.Duration) *RedisCache {
+	cache.lifetime = lifetime
+	return cache
+}
+
+// Record the host to which the cached values belong.
+func (cache *RedisCache) WithHost(host string) *RedisCache {
+	cache.host = host
+	return cache
+}
+
+// Return the server URL, without any password.
+func (cache *RedisCache) Location() string {
+	if u, err := url.Parse(cache.serverURL); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+
+	return cache.serverURL
+}
+
+// Connect to the server. Starting a cache whose server is down is an
+// error, so that callers can fall back to running without a cache.
+func (cache *RedisCache) Start() error {
+	client, err := newRedisClient(cache.serverURL)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.do("PING"); err != nil {
+		return fmt.Errorf("%s: %w", cache.Location(), err)
+	}
+	cache.client = client
+
+	if cache.cacheName == "" {
+		return nil
+	}
+
+	return cache.createBucket()
+}
+
+func (cache *RedisCache) Close() error {
+	if cache.client == nil {
+		return nil
+	}
+
+	return cache.client.Close()
+}
+
+// Record the metadata for our bucket. Redis has no need to create the
+// bucket itself.
+func (cache *RedisCache) createBucket() error {
+	if cache.host == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(bucketMetadata{Host: cache.host})
+	if err != nil {
+		return err
+	}
+
+	_, err = cache.client.do("SET", cache.redisKey(metadataBucket, cache.cacheName), data)
+	return err
+}
+
+// Return the cache for the named bucket. Buckets share a connection.
+func (cache *RedisCache) Bucket(cacheName, host string) (Cache, error) {
+	if cache.client == nil {
+		return nil, fmt.Errorf("cache has not been started")
+	}
+
+	newCache := &RedisCache{
+		serverURL: cache.serverURL,
+		appName:   cache.appName,
+		cacheName: cacheName,
+		host:      host,
+		lifetime:  cache.lifetime,
+		client:    cache.client,
+	}
+
+	if err := newCache.createBucket(); err != nil {
+		return nil, err
+	}
+
+	return newCache, nil
+}
+
+func (cache *RedisCache) redisKey(bucket, key string) string {
+	return cache.appName + ":" + bucket + ":" + key
+}
+
+func (cache *RedisCache) Get(key string) ([]byte, error) {
+	value, err := cache.GetStale(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	// Return nil if cache value has expired.
+	if expired(cache.lifetime, value.Timestamp) {
+		return nil, nil
+	}
+
+	return value.Data, nil
+}
+
+// Return the value for key regardless of age, or nil if there is no
+// value.
+func (cache *RedisCache) GetStale(key string) (*Value, error) {
+	reply, err := cache.client.do("GET", cache.redisKey(cache.cacheName, key))
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := reply.([]byte)
+	if data == nil {
+		return nil, nil
+	}
+
+	return decodeValue(data)
+}
+
+func (cache *RedisCache) Put(key string, value []byte) error {
+	return cache.PutWithVersion(key, value, "")
+}
+
+// Store a value along with the version reported by the server.
+func (cache *RedisCache) PutWithVersion(key string, value []byte, version string) error {
+	return cache.PutValue(key, &Value{Data: value, Timestamp: time.Now(), Version: version})
+}
+
+// Store a value with the given timestamp and version.
+func (cache *RedisCache) PutValue(key string, value *Value) error {
+	data, err := encodeValue(value.Data, value.Timestamp, value.Version)
+	if err != nil {
+		return err
+	}
+
+	_, err = cache.client.do("SET", cache.redisKey(cache.cacheName, key), data)
+	return err
+}
+
+// Store several values with a single command.
+func (cache *RedisCache) PutMany(values map[string][]byte) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	args := []interface{}{"MSET"}
+	for key, value := range values {
+		data, err := encodeValue(value, now, "")
+		if err != nil {
+			return err
+		}
+		args = append(args, cache.redisKey(cache.cacheName, key), data)
+	}
+
+	_, err := cache.client.do(args...)
+	return err
+}
+
+func (cache *RedisCache) Delete(key string) error {
+	return cache.client.del(cache.redisKey(cache.cacheName, key))
+}
+
+// Return information about each value in the cache.
+func (cache *RedisCache) Entries() ([]Entry, error) {
+	buckets, err := cache.scanBuckets(cache.cacheName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range buckets {
+		return bucket.Entries, nil
+	}
+
+	return nil, nil
+}
+
+// Return information about the named bucket, or about every bucket if
+// name is "*".
+func (cache *RedisCache) scanBuckets(name string) ([]Bucket, error) {
+	keys, err := cache.client.scan(cache.redisKey(name, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := cache.client.mget(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	index := make(map[string]int)
+	prefix := cache.appName + ":"
+
+	for i, redisKey := range keys {
+		bucketName, key, found := strings.Cut(strings.TrimPrefix(redisKey, prefix), ":")
+		if !found || values[i] == nil {
+			continue
+		}
+
+		if bucketName == metadataBucket {
+			bucketName, key = key, ""
+		}
+
+		pos, ok := index[bucketName]
+		if !ok {
+			pos = len(buckets)
+			index[bucketName] = pos
+			buckets = append(buckets, Bucket{Name: bucketName})
+		}
+
+		if key == "" {
+			var md bucketMetadata
+			if err := json.Unmarshal(values[i], &md); err == nil {
+				buckets[pos].Host = md.Host
+			}
+			continue
+		}
+
+		buckets[pos].Entries = append(buckets[pos].Entries, newEntry(key, values[i], cache.lifetime))
+	}
+
+	return buckets, nil
+}
+
+// Return information about every bucket on the server.
+func (cache *RedisCache) Buckets() ([]Bucket, error) {
+	return cache.scanBuckets("*")
+}
+
+// Delete a bucket and everything in it.
+func (cache *RedisCache) DeleteBucket(name string) error {
+	if name == metadataBucket || name == "" || strings.ContainsAny(name, "*?[:") {
+		return fmt.Errorf("%s is not a cache bucket", name)
+	}
+
+	keys, err := cache.client.scan(cache.redisKey(name, "*"))
+	if err != nil {
+		return err
+	}
+
+	return cache.client.del(append(keys, cache.redisKey(metadataBucket, name))...)
+}
+
+// Delete expired values from every bucket and return the number of
+// values deleted.
+func (cache *RedisCache) Prune() (int, error) {
+	buckets, err := cache.Buckets()
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	for _, bucket := range buckets {
+		for _, entry := range bucket.Entries {
+			if entry.Expired {
+				keys = append(keys, cache.redisKey(bucket.Name, entry.Key))
+			}
+		}
+	}
+
+	if err := cache.client.del(keys...); err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// The version of the database layout written by this version of
+	// kola. Databases written before we started recording a version
+	// are version 1.
+	schemaVersion = 3
+
+	// Where we record the schema version, in the metadata bucket.
+	schemaKey = "schema"
+
+	// Start moves an unreadable database aside by adding this suffix
+	// and the time to its name...
+	quarantineSuffix     = ".corrupt-"
+	quarantineTimeFormat = "20060102T150405"
+
+	// ...and Maintain deletes it after this long.
+	quarantineLifetime = 7 * 24 * time.Hour
+)
+
+var (
+	// Returned when the database can't be read. Start moves such a
+	// database out of the way and starts afresh.
+	ErrCorrupt = errors.New("cache database is corrupt")
+
+	// Returned when the database was written by a newer version of
+	// kola, which we leave alone.
+	ErrSchemaTooNew = errors.New("cache database was written by a newer version of kola")
+)
+
+// Each migration upgrades a database from schema version i+1 to i+2, in
+// the same transaction that records the new version.
+var migrations = []func(tx *bolt.Tx) error{
+	// Version 2 records metadata, including when the bucket was last
+	// used, for every bucket.
+	migrateBucketMetadata,
+
+	// Version 3 may encrypt values and bucket metadata (see
+	// encryption.go), which earlier versions would misread. There is
+	// nothing to change.
+	func(tx *bolt.Tx) error { return nil },
+}
+
+func migrateBucketMetadata(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+	if err != nil {
+		return err
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if string(name) == metadataBucket {
+			return nil
+		}
+
+		key := []byte("bucket/" + string(name))
+
+		var md bucketMetadata
+		if data := meta.Get(key); data != nil {
+			if err := json.Unmarshal(data, &md); err != nil {
+				return err
+			}
+		}
+
+		if !md.LastUsed.IsZero() {
+			return nil
+		}
+
+		// We don't know when the bucket was last used, so we use the
+		// time of its most recent value instead.
+		//nolint:errcheck
+		b.ForEach(func(k, v []byte) error {
+			if value, err := decodeValue(v); err == nil && value.Timestamp.After(md.LastUsed) {
+				md.LastUsed = value.Timestamp
+			}
+			return nil
+		})
+
+		data, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+
+		return meta.Put(key, data)
+	})
+}
+
+// Run fn, turning a panic into ErrCorrupt. Bolt panics when it finds
+// some kinds of damage to the database.
+func guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrCorrupt, r)
+		}
+	}()
+
+	return fn()
+}
+
+// Return true if an error from bolt.Open means that the database can't
+// be read, rather than that we couldn't open, lock or map the file.
+func isCorrupt(err error) bool {
+	return errors.Is(err, bolt.ErrInvalid) ||
+		errors.Is(err, bolt.ErrChecksum) ||
+		errors.Is(err, bolt.ErrVersionMismatch) ||
+		// Bolt doesn't export this one, which it returns for a file
+		// too short to hold the meta pages.
+		err.Error() == "file size too small"
+}
+
+// Return the schema version of the database, and whether it was recorded
+// in the database.
+func readSchemaVersion(tx *bolt.Tx) (int, bool, error) {
+	meta := tx.Bucket([]byte(metadataBucket))
+	if meta != nil {
+		if data := meta.Get([]byte(schemaKey)); data != nil {
+			version, err := strconv.Atoi(string(data))
+			if err != nil {
+				return 0, false, fmt.Errorf("%w: invalid schema version %q", ErrCorrupt, data)
+			}
+			return version, true, nil
+		}
+	}
+
+	// A new database has no buckets; anything else was written before
+	// we started recording a version.
+	if k, _ := tx.Cursor().First(); k == nil {
+		return schemaVersion, false, nil
+	}
+
+	return 1, false, nil
+}
+
+// Return an error if we don't understand the database. Reading the schema
+// version also serves as a quick check that the database is readable;
+// Maintain performs a thorough one.
+func (cache *BoltCache) checkSchema() (version int, recorded bool, err error) {
+	err = guard(func() error {
+		return cache.db.View(func(tx *bolt.Tx) error {
+			version, recorded, err = readSchemaVersion(tx)
+			if err != nil {
+				return err
+			}
+
+			if version > schemaVersion {
+				return fmt.Errorf("%w (schema version %d)", ErrSchemaTooNew, version)
+			}
+
+			return nil
+		})
+	})
+
+	return version, recorded, err
+}
+
+// Check that we understand the database and bring it up to date.
+func (cache *BoltCache) migrate() error {
+	version, recorded, err := cache.checkSchema()
+	if err != nil || (recorded && version == schemaVersion) {
+		return err
+	}
+
+	return guard(func() error {
+		return cache.db.Update(func(tx *bolt.Tx) error {
+			for ; version < schemaVersion; version++ {
+				if err := migrations[version-1](tx); err != nil {
+					return fmt.Errorf("upgrading cache to schema version %d: %w", version+1, err)
+				}
+			}
+
+			meta, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+			if err != nil {
+				return err
+			}
+
+			return meta.Put([]byte(schemaKey), []byte(strconv.Itoa(schemaVersion)))
+		})
+	})
+}
+
+// Check every page of the database.
+func (cache *BoltCache) check() error {
+	return guard(func() error {
+		return cache.db.View(func(tx *bolt.Tx) error {
+			var first error
+
+			// Check reports errors from a goroutine, so we must
+			// read all of them.
+			for err := range tx.Check() {
+				if first == nil {
+					first = fmt.Errorf("%w: %v", ErrCorrupt, err)
+				}
+			}
+
+			return first
+		})
+	})
+}
+
+// Move an unreadable database out of the way, so that we can start
+// afresh, and remember where we put it.
+func (cache *BoltCache) quarantine() error {
+	path := cache.Path()
+	quarantined := fmt.Sprintf("%s%s%s", path, quarantineSuffix, time.Now().Format(quarantineTimeFormat))
+
+	if err := os.Rename(path, quarantined); err != nil {
+		return err
+	}
+
+	cache.quarantined = quarantined
+	return nil
+}
+
+// Delete the databases that Start set aside more than quarantineLifetime
+// ago, and return their paths.
+func (cache *BoltCache) removeQuarantined() []string {
+	entries, err := os.ReadDir(cache.cacheDirectory)
+	if err != nil {
+		return nil
+	}
+
+	prefix := filepath.Base(cache.Path()) + quarantineSuffix
+
+	var removed []string
+	for _, entry := range entries {
+		suffix := strings.TrimPrefix(entry.Name(), prefix)
+		if suffix == entry.Name() {
+			continue
+		}
+
+		// The file's modification time is when it was last written,
+		// which may be long before we set it aside.
+		quarantinedAt, err := time.ParseInLocation(quarantineTimeFormat, suffix, time.Local)
+		if err != nil || time.Since(quarantinedAt) < quarantineLifetime {
+			continue
+		}
+
+		path := filepath.Join(cache.cacheDirectory, entry.Name())
+		if err := os.Remove(path); err == nil {
+			removed = append(removed, path)
+		}
+	}
+
+	return removed
+}
+
+// Return the path to which Start moved an unreadable database, or an
+// empty string if it didn't.
+func (cache *BoltCache) Quarantined() string {
+	return cache.quarantined
+}
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A database written before the cache recorded a schema version.
+const legacyDatabase = "testdata/legacy.db"
+
+func TestUpgrade(t *testing.T) {
+	dir := t.TempDir()
+
+	data, err := os.ReadFile(legacyDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cache.db"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		store := newStore(dir)
+		if err := store.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		buckets, err := store.Buckets()
+		store.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(buckets) != 1 || buckets[0].Name != "test" || len(buckets[0].Entries) != 1 {
+			t.Errorf("unexpected buckets %+v", buckets)
+		}
+	}
+
+	if version := readMetadata(t, dir, schemaKey); string(version) != fmt.Sprint(schemaVersion) {
+		t.Errorf("schema version is %q", version)
+	}
+
+	var md bucketMetadata
+	if err := json.Unmarshal(readMetadata(t, dir, "bucket/test"), &md); err != nil {
+		t.Fatal(err)
+	}
+	if !md.LastUsed.Equal(time.Date(2022, 12, 2, 13, 27, 49, 0, time.UTC)) {
+		t.Errorf("bucket last used at %s, expected the time of its value", md.LastUsed)
+	}
+
+	c := startStore(t, NewCache("kola", "test").WithCacheDirectory(dir))
+	value, err := c.GetStale("testval")
+	if err != nil || value == nil || string(value.Data) != "3" {
+		t.Errorf("unable to read old value: %v %+v", err, value)
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+
+	store := NewCache("kola", "test").WithCacheDirectory(dir)
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	writeMetadata(t, dir, schemaKey, []byte("99"))
+
+	store = NewCache("kola", "test").WithCacheDirectory(dir)
+	err := store.Start()
+	if err == nil {
+		store.Close()
+	}
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Start returned %v", err)
+	}
+
+	if version := readMetadata(t, dir, schemaKey); string(version) != "99" {
+		t.Errorf("database was modified (schema version %q)", version)
+	}
+}
+
+// Call fn with each page of the database, and write back the result.
+func overwritePages(t *testing.T, path string, fn func(id int, page []byte)) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageSize := os.Getpagesize()
+	for id := 0; (id+1)*pageSize <= len(data); id++ {
+		fn(id, data[id*pageSize:(id+1)*pageSize])
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		damage func(t *testing.T, path string)
+	}{
+		{"garbage", func(t *testing.T, path string) {
+			if err := os.WriteFile(path, bytes.Repeat([]byte("kola"), 16384), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"truncated", func(t *testing.T, path string) {
+			if err := os.Truncate(path, 100); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		// Overwrite the checksums of both meta pages. The checksum is
+		// the last field of the meta structure, which follows the 16
+		// byte page header.
+		{"meta pages", func(t *testing.T, path string) {
+			overwritePages(t, path, func(id int, page []byte) {
+				if id < 2 {
+					copy(page[72:80], bytes.Repeat([]byte{0xff}, 8))
+				}
+			})
+		}},
+		// Change the type of the last leaf page, which holds some of
+		// our values. Bolt only notices when it reads the page, which
+		// it does when Maintain checks the database.
+		{"leaf page", func(t *testing.T, path string) {
+			last := -1
+			overwritePages(t, path, func(id int, page []byte) {
+				if binary.LittleEndian.Uint16(page[8:]) == 0x02 {
+					last = id
+				}
+			})
+			overwritePages(t, path, func(id int, page []byte) {
+				if id == last {
+					binary.LittleEndian.PutUint16(page[8:], 0x20)
+				}
+			})
+		}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			store := NewCache("kola", "test").WithCacheDirectory(dir)
+			if err := store.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			values := make(map[string][]byte)
+			for i := 0; i < 200; i++ {
+				values[fmt.Sprintf("key%03d", i)] = bytes.Repeat([]byte("x"), 100)
+			}
+			if err := store.PutMany(values); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			path := filepath.Join(dir, "cache.db")
+			test.damage(t, path)
+
+			damaged, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A maximum age makes Start check the database.
+			store = startStore(t, NewCache("kola", "test").WithCacheDirectory(dir).WithMaxAge(time.Hour))
+
+			quarantined := store.Quarantined()
+			if quarantined == "" {
+				t.Fatalf("database was not set aside")
+			}
+			if data, err := os.ReadFile(quarantined); err != nil || !bytes.Equal(data, damaged) {
+				t.Errorf("damaged database was not preserved")
+			}
+
+			if data, _ := store.Get("key000"); data != nil {
+				t.Errorf("value survived")
+			}
+
+			if err := store.Put("new", []byte("value")); err != nil {
+				t.Errorf("unable to write to rebuilt cache: %v", err)
+			}
+			if data, _ := store.Get("new"); string(data) != "value" {
+				t.Errorf("unable to read from rebuilt cache")
+			}
+		})
+	}
+}
+
+func TestIsCorrupt(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		corrupt bool
+	}{
+		{bolt.ErrInvalid, true},
+		{bolt.ErrChecksum, true},
+		{bolt.ErrVersionMismatch, true},
+		{errors.New("file size too small"), true},
+		{bolt.ErrTimeout, false},
+		{&fs.PathError{Op: "open", Path: "cache.db", Err: fs.ErrPermission}, false},
+		{errors.New("mmap too large"), false},
+	} {
+		if corrupt := isCorrupt(test.err); corrupt != test.corrupt {
+			t.Errorf("isCorrupt(%v) = %t, expected %t", test.err, corrupt, test.corrupt)
+		}
+	}
+}
+
+func TestRemoveQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	store := startStore(t, newStore(dir).WithMaxAge(time.Hour))
+
+	// Old databases go; recent ones, and files that only look like
+	// them, stay.
+	now := time.Now()
+	old := store.Path() + quarantineSuffix + now.Add(-quarantineLifetime-time.Hour).Format(quarantineTimeFormat)
+	recent := store.Path() + quarantineSuffix + now.Add(-time.Hour).Format(quarantineTimeFormat)
+	other := store.Path() + quarantineSuffix + "backup"
+	for _, path := range []string{old, recent, other} {
+		if err := os.WriteFile(path, []byte("kola"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := store.Maintain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != old {
+		t.Errorf("Maintain removed %v, expected %s", result.Removed, old)
+	}
+
+	for _, path := range []string{recent, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
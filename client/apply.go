@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// The field manager recorded for fields we set with server-side apply.
+const FieldManager = "kola"
+
+type (
+	// Applies resources to a cluster using server-side apply.
+	Applier struct {
+		client dynamic.Interface
+		dryRun bool
+		force  bool
+	}
+)
+
+func NewApplier(kubeconfig, contextName string) (*Applier, error) {
+	config, err := BuildConfigFromFlags("", kubeconfig, contextName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Kubernetes config: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	return &Applier{client: client}, nil
+}
+
+// Ask the API server to validate resources without persisting them.
+func (applier *Applier) WithDryRun(dryRun bool) *Applier {
+	applier.dryRun = dryRun
+	return applier
+}
+
+// Take ownership of fields that another field manager has set, rather
+// than failing with a conflict.
+func (applier *Applier) WithForce(force bool) *Applier {
+	applier.force = force
+	return applier
+}
+
+// Create or update obj, which must have its apiVersion and kind set, and
+// return the result. The resource for obj is derived from its kind
+// (e.g. Subscription -> subscriptions), which holds for the resources kola
+// generates.
+func (applier *Applier) Apply(ctx context.Context, obj runtime.Object) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	// Typed objects always have these fields, but they aren't ours to
+	// set.
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+
+	data, err = json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+
+	gvr, _ := meta.UnsafeGuessKindToResource(u.GroupVersionKind())
+
+	options := metav1.PatchOptions{FieldManager: FieldManager}
+	if applier.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	if applier.force {
+		options.Force = &applier.force
+	}
+
+	resources := applier.client.Resource(gvr)
+	var resource dynamic.ResourceInterface = resources
+	if ns := u.GetNamespace(); ns != "" {
+		resource = resources.Namespace(ns)
+	}
+
+	return resource.Patch(ctx, u.GetName(), types.ApplyPatchType, data, options)
+}
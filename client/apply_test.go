@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	operatorsv1 "github.com/operator-framework/api/pkg/operators/v1"
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+type (
+	// The fake dynamic client doesn't record PatchOptions, so we wrap
+	// it to see them.
+	optionsRecorder struct {
+		*fake.FakeDynamicClient
+		options []metav1.PatchOptions
+	}
+
+	optionsRecorderResource struct {
+		dynamic.NamespaceableResourceInterface
+		recorder *optionsRecorder
+	}
+
+	optionsRecorderNamespacedResource struct {
+		dynamic.ResourceInterface
+		recorder *optionsRecorder
+	}
+)
+
+func (c *optionsRecorder) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &optionsRecorderResource{c.FakeDynamicClient.Resource(gvr), c}
+}
+
+func (r *optionsRecorderResource) Namespace(ns string) dynamic.ResourceInterface {
+	return &optionsRecorderNamespacedResource{r.NamespaceableResourceInterface.Namespace(ns), r.recorder}
+}
+
+func (r *optionsRecorderResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.recorder.options = append(r.recorder.options, options)
+	return r.NamespaceableResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+func (r *optionsRecorderNamespacedResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.recorder.options = append(r.recorder.options, options)
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+// Return a fake client that answers every patch with the patch itself.
+func newOptionsRecorder() *optionsRecorder {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		u := &unstructured.Unstructured{}
+		err := u.UnmarshalJSON(action.(clienttesting.PatchAction).GetPatch())
+		return true, u, err
+	})
+
+	return &optionsRecorder{FakeDynamicClient: client}
+}
+
+func TestApply(t *testing.T) {
+	namespace := &corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+	}
+	operatorGroup := &operatorsv1.OperatorGroup{
+		TypeMeta:   metav1.TypeMeta{APIVersion: operatorsv1.SchemeGroupVersion.String(), Kind: "OperatorGroup"},
+		ObjectMeta: metav1.ObjectMeta{Name: "team", Namespace: "team"},
+	}
+	subscription := &operatorsv1alpha1.Subscription{
+		TypeMeta:   metav1.TypeMeta{APIVersion: operatorsv1alpha1.SchemeGroupVersion.String(), Kind: "Subscription"},
+		ObjectMeta: metav1.ObjectMeta{Name: "flux", Namespace: "team"},
+		Spec:       &operatorsv1alpha1.SubscriptionSpec{Package: "flux", Channel: "stable"},
+	}
+
+	for _, test := range []struct {
+		name   string
+		dryRun bool
+		force  bool
+	}{
+		{"apply", false, false},
+		{"dry run", true, false},
+		{"force", false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := newOptionsRecorder()
+			applier := (&Applier{client: client}).WithDryRun(test.dryRun).WithForce(test.force)
+
+			for _, obj := range []runtime.Object{namespace, operatorGroup, subscription} {
+				if _, err := applier.Apply(context.Background(), obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expectedActions := []struct {
+				resource  schema.GroupVersionResource
+				namespace string
+				name      string
+			}{
+				{schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "", "team"},
+				{operatorsv1.SchemeGroupVersion.WithResource("operatorgroups"), "team", "team"},
+				{operatorsv1alpha1.SchemeGroupVersion.WithResource("subscriptions"), "team", "flux"},
+			}
+
+			actions := client.Actions()
+			if len(actions) != len(expectedActions) {
+				t.Fatalf("expected %d actions, got %d", len(expectedActions), len(actions))
+			}
+
+			for i, action := range actions {
+				patch := action.(clienttesting.PatchAction)
+				expected := expectedActions[i]
+
+				if patch.GetResource() != expected.resource || patch.GetNamespace() != expected.namespace || patch.GetName() != expected.name {
+					t.Errorf("unexpected patch of %s %s/%s", patch.GetResource(), patch.GetNamespace(), patch.GetName())
+				}
+				if patch.GetPatchType() != types.ApplyPatchType {
+					t.Errorf("%s: unexpected patch type %s", patch.GetName(), patch.GetPatchType())
+				}
+
+				// Typed objects always have these, but they aren't
+				// ours to set.
+				var obj map[string]interface{}
+				if err := json.Unmarshal(patch.GetPatch(), &obj); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := obj["status"]; ok {
+					t.Errorf("%s: status was not removed", patch.GetName())
+				}
+				if _, ok := obj["metadata"].(map[string]interface{})["creationTimestamp"]; ok {
+					t.Errorf("%s: creationTimestamp was not removed", patch.GetName())
+				}
+			}
+
+			expectedOptions := metav1.PatchOptions{FieldManager: FieldManager}
+			if test.dryRun {
+				expectedOptions.DryRun = []string{metav1.DryRunAll}
+			}
+			if test.force {
+				force := true
+				expectedOptions.Force = &force
+			}
+
+			if len(client.options) != len(expectedActions) {
+				t.Fatalf("expected %d sets of options, got %d", len(expectedActions), len(client.options))
+			}
+			for _, options := range client.options {
+				if !reflect.DeepEqual(options, expectedOptions) {
+					t.Errorf("expected options %+v, got %+v", expectedOptions, options)
+				}
+			}
+		})
+	}
+}
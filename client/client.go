@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"sort"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -17,25 +18,38 @@ import (
 // 2. Otherwise, first check for the KUBECONFIG environment variable and
 //    the default config file location.
 // 3. Lastly, check for an in-cluster configuration.
-func BuildConfigFromFlags(masterUrl, kubeconfigPath string) (*restclient.Config, error) {
+//
+// If contextName is not empty, use the named context rather than the
+// current context.
+func BuildConfigFromFlags(masterUrl, kubeconfigPath, contextName string) (*restclient.Config, error) {
 	if kubeconfigPath == "" && masterUrl == "" {
-		if kubeconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		kubeconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			clientcmd.NewDefaultClientConfigLoadingRules(),
-			nil,
-		).ClientConfig(); err == nil {
+			&clientcmd.ConfigOverrides{CurrentContext: contextName},
+		).ClientConfig()
+		if err == nil {
 			return kubeconfig, nil
 		}
 
+		// A named context can only come from the client configuration,
+		// so don't fall back to the in-cluster configuration.
+		if contextName != "" {
+			return nil, err
+		}
+
 		return restclient.InClusterConfig()
 	}
 
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-		&clientcmd.ConfigOverrides{ClusterInfo: clientcmdapi.Cluster{Server: masterUrl}}).ClientConfig()
+		&clientcmd.ConfigOverrides{
+			ClusterInfo:    clientcmdapi.Cluster{Server: masterUrl},
+			CurrentContext: contextName,
+		}).ClientConfig()
 }
 
-func GetClient(kubeconfig string) (*rest.Config, *kubernetes.Clientset, error) {
-	config, err := BuildConfigFromFlags("", kubeconfig)
+func GetClient(kubeconfig, contextName string) (*rest.Config, *kubernetes.Clientset, error) {
+	config, err := BuildConfigFromFlags("", kubeconfig, contextName)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to build Kuberbetes config: %w", err)
 	}
@@ -47,3 +61,20 @@ func GetClient(kubeconfig string) (*rest.Config, *kubernetes.Clientset, error) {
 
 	return config, clientset, nil
 }
+
+// Return the names of all contexts defined in the client configuration,
+// in sorted order.
+func GetContexts(kubeconfigPath string) ([]string, error) {
+	config, err := loadingRules(kubeconfigPath).Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kubernetes config: %w", err)
+	}
+
+	var contexts []string
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	return contexts, nil
+}
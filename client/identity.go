@@ -0,0 +1,176 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type (
+	// Who we are when talking to a cluster. Different users may see
+	// different packages (because of RBAC or namespace-scoped catalog
+	// sources), so results from one shouldn't be used for another.
+	Identity struct {
+		// The kubeconfig context and the name of the user entry it
+		// refers to. Both are empty when using the in-cluster
+		// configuration.
+		Context string
+		User    string
+
+		// A hash of where our credentials come from (see
+		// credentialSource). The name of a kubeconfig user entry
+		// says nothing about who it authenticates as, and two
+		// kubeconfigs may use the same name for different users.
+		Credentials string
+
+		// The user, uid and groups we impersonate, if any.
+		ImpersonateUser   string
+		ImpersonateUID    string
+		ImpersonateGroups []string
+	}
+)
+
+// Return the rules for loading the client configuration from the given
+// path, or from the default locations if path is empty.
+func loadingRules(kubeconfigPath string) *clientcmd.ClientConfigLoadingRules {
+	if kubeconfigPath != "" {
+		return &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath}
+	}
+
+	return clientcmd.NewDefaultClientConfigLoadingRules()
+}
+
+// Return the identity used by config, which was built (by
+// BuildConfigFromFlags) from the given kubeconfig path and context.
+// Where possible the identity depends on the source of our credentials
+// rather than the credentials themselves: a user whose token is refreshed
+// is still the same user.
+func GetIdentity(kubeconfigPath, contextName string, config *rest.Config) *Identity {
+	identity := &Identity{
+		Credentials:       hashCredentialSource(config),
+		ImpersonateUser:   config.Impersonate.UserName,
+		ImpersonateUID:    config.Impersonate.UID,
+		ImpersonateGroups: append([]string(nil), config.Impersonate.Groups...),
+	}
+	sort.Strings(identity.ImpersonateGroups)
+
+	raw, err := loadingRules(kubeconfigPath).Load()
+	if err != nil {
+		return identity
+	}
+
+	if contextName == "" {
+		contextName = raw.CurrentContext
+	}
+
+	if context, ok := raw.Contexts[contextName]; ok {
+		identity.Context = contextName
+		identity.User = context.AuthInfo
+	}
+
+	return identity
+}
+
+// Describe where the credentials in config come from: the command that
+// provides them, the file that holds the token, the subject of the client
+// certificate, and so on. A token given directly in the kubeconfig is the
+// only thing that identifies its user, so we use the token itself.
+func credentialSource(config *rest.Config) []string {
+	var source []string
+
+	if config.ExecProvider != nil {
+		source = append(source, "exec="+strings.Join(append([]string{config.ExecProvider.Command}, config.ExecProvider.Args...), " "))
+	}
+
+	if config.AuthProvider != nil {
+		source = append(source, "auth-provider="+config.AuthProvider.Name)
+	}
+
+	if config.BearerTokenFile != "" {
+		source = append(source, "token-file="+config.BearerTokenFile)
+	} else if config.BearerToken != "" {
+		source = append(source, "token="+config.BearerToken)
+	}
+
+	if subject := certificateSubject(config); subject != "" {
+		source = append(source, "cert="+subject)
+	}
+
+	if config.Username != "" {
+		source = append(source, "username="+config.Username)
+	}
+
+	return source
+}
+
+// Return a short hash of credentialSource, or an empty string if config
+// has no credentials.
+func hashCredentialSource(config *rest.Config) string {
+	source := credentialSource(config)
+	if len(source) == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(source, "\n")))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// Return the subject of the client certificate in config, or an empty
+// string if there is none (or we can't read it).
+func certificateSubject(config *rest.Config) string {
+	data := config.CertData
+	if len(data) == 0 && config.CertFile != "" {
+		var err error
+		if data, err = os.ReadFile(config.CertFile); err != nil {
+			return ""
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return ""
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+
+	return cert.Subject.String()
+}
+
+// Describe the identity, e.g. "context=dev,user=admin,as=jane".
+func (identity *Identity) String() string {
+	var parts []string
+
+	if identity.Context == "" && identity.User == "" {
+		parts = append(parts, "in-cluster")
+	} else {
+		parts = append(parts, "context="+identity.Context, "user="+identity.User)
+	}
+
+	if identity.Credentials != "" {
+		parts = append(parts, "credentials="+identity.Credentials)
+	}
+
+	if identity.ImpersonateUser != "" {
+		parts = append(parts, "as="+identity.ImpersonateUser)
+	}
+
+	if identity.ImpersonateUID != "" {
+		parts = append(parts, "as-uid="+identity.ImpersonateUID)
+	}
+
+	if len(identity.ImpersonateGroups) > 0 {
+		parts = append(parts, "as-groups="+strings.Join(identity.ImpersonateGroups, "+"))
+	}
+
+	return strings.Join(parts, ",")
+}
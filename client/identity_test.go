@@ -0,0 +1,183 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write a kubeconfig with a single context, "dev", whose user is called
+// "admin" and has the given configuration, and return its path.
+func writeKubeconfig(t *testing.T, dir, name, user string) string {
+	t.Helper()
+
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://cluster.example.com:6443
+    insecure-skip-tls-verify: true
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+users:
+- name: admin
+  user:
+%s`, user)
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Write a self-signed client certificate and key for the given common
+// name, and return their paths.
+func writeCertificate(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"system:masters"}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, commonName+".crt")
+	keyPath := filepath.Join(dir, commonName+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func getTestIdentity(t *testing.T, path string) *Identity {
+	t.Helper()
+
+	config, err := BuildConfigFromFlags("", path, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return GetIdentity(path, "dev", config)
+}
+
+func TestIdentityCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".token"), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aliceCert, aliceKey := writeCertificate(t, dir, "alice")
+	bobCert, bobKey := writeCertificate(t, dir, "bob")
+
+	for _, test := range []struct {
+		name       string
+		users      [2]string
+		sameSource bool
+	}{
+		{
+			"tokens",
+			[2]string{"    token: alice\n", "    token: bob\n"},
+			false,
+		},
+		{
+			"token files",
+			[2]string{
+				fmt.Sprintf("    tokenFile: %s\n", filepath.Join(dir, "alice.token")),
+				fmt.Sprintf("    tokenFile: %s\n", filepath.Join(dir, "bob.token")),
+			},
+			false,
+		},
+		{
+			"certificates",
+			[2]string{
+				fmt.Sprintf("    client-certificate: %s\n    client-key: %s\n", aliceCert, aliceKey),
+				fmt.Sprintf("    client-certificate: %s\n    client-key: %s\n", bobCert, bobKey),
+			},
+			false,
+		},
+		{
+			"exec commands",
+			[2]string{
+				"    exec:\n      apiVersion: client.authentication.k8s.io/v1beta1\n      command: get-token\n      args: [alice]\n",
+				"    exec:\n      apiVersion: client.authentication.k8s.io/v1beta1\n      command: get-token\n      args: [bob]\n",
+			},
+			false,
+		},
+		{
+			// A refreshed token is still the same user.
+			"same token file",
+			[2]string{
+				fmt.Sprintf("    tokenFile: %s\n", filepath.Join(dir, "alice.token")),
+				fmt.Sprintf("    tokenFile: %s\n", filepath.Join(dir, "alice.token")),
+			},
+			true,
+		},
+	} {
+		first := getTestIdentity(t, writeKubeconfig(t, dir, "first", test.users[0]))
+		second := getTestIdentity(t, writeKubeconfig(t, dir, "second", test.users[1]))
+
+		if first.Context != "dev" || first.User != "admin" || first.Credentials == "" {
+			t.Errorf("%s: unexpected identity %s", test.name, first)
+		}
+		if same := first.String() == second.String(); same != test.sameSource {
+			t.Errorf("%s: identities %s and %s: same is %t", test.name, first, second, same)
+		}
+	}
+}
+
+func TestIdentityImpersonation(t *testing.T) {
+	dir := t.TempDir()
+	path := writeKubeconfig(t, dir, "config", "    token: alice\n    as: jane\n    as-groups: [developers, admins]\n")
+
+	identity := getTestIdentity(t, path)
+
+	if identity.ImpersonateUser != "jane" {
+		t.Errorf("unexpected impersonated user %q", identity.ImpersonateUser)
+	}
+	if expected := []string{"admins", "developers"}; !reflect.DeepEqual(identity.ImpersonateGroups, expected) {
+		t.Errorf("expected groups %v, got %v", expected, identity.ImpersonateGroups)
+	}
+	if !strings.HasSuffix(identity.String(), ",as=jane,as-groups=admins+developers") {
+		t.Errorf("unexpected identity %s", identity)
+	}
+}
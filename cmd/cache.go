@@ -0,0 +1,606 @@
+/*
+Copyright © 2022 Lars Kellogg-Stedman <lars@oddbit.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"kola/cache"
+	"kola/client"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type (
+	CacheClearFlags struct {
+		All bool `help:"Clear all buckets"`
+	}
+
+	CacheExportFlags struct {
+		Decrypt bool `help:"Export an encrypted cache, writing its contents to the archive unencrypted"`
+	}
+)
+
+var cacheClearFlags = CacheClearFlags{}
+var cacheExportFlags = CacheExportFlags{}
+
+// Cache buckets are named with a sha256 hash; we show only this many
+// characters of the name.
+const shortBucketNameLength = 12
+
+// Abbreviated bucket names given on the command line must have at least
+// this many characters, so that a stray short argument can't select a
+// bucket by accident.
+const minBucketPrefixLength = 6
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local cache",
+	Long: `Manage the local cache. Cached values are stored in one bucket per
+cluster (or other package source); buckets are identified by a hash and
+record the host to which they belong.`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List cache buckets and their contents",
+	RunE:         runCacheList,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [BUCKET|HOST...]",
+	Short: "Clear cache buckets",
+	Long: `Clear cache buckets. Buckets may be selected by (a prefix of) the bucket
+name or by host; use --all to clear every bucket.`,
+	RunE:         runCacheClear,
+	SilenceUsage: true,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:          "prune",
+	Short:        "Delete expired cache entries and apply the cache size limits",
+	RunE:         runCachePrune,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export FILE [BUCKET|HOST...]",
+	Short: "Export cache buckets to a file",
+	Long: `Export cache buckets, along with their hosts and timestamps, to a
+gzipped tar archive that can be loaded on another machine using "kola
+cache import". Buckets may be selected by (a prefix of) the bucket name or
+by host; by default every bucket is exported. Use "-" to write the archive
+to stdout.
+
+Archives are not encrypted, so exporting an encrypted cache requires
+--decrypt.`,
+	RunE:         runCacheExport,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Import cache buckets from a file",
+	Long: `Import cache buckets from an archive written by "kola cache export". Use
+"-" to read the archive from stdin. Cached values are only replaced by
+newer ones.
+
+Imported packages can be used without access to the cluster by selecting
+the bucket with --from-cache (by host or bucket name).`,
+	RunE:         runCacheImport,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+var cacheIdentityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Show the identity under which results are cached",
+	Long: `Show the identity under which results from each selected context are
+cached, and the bucket in which they are stored. By default the identity
+is derived from the kubeconfig context, the user it refers to, the
+source of that user's credentials and any impersonation settings, so
+that users who may see different packages don't share cached results.
+Use --cache-identity to override it (for example, to share a cache
+between users who see the same packages).`,
+	RunE:         runCacheIdentity,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:          "stats",
+	Short:        "Show cache statistics",
+	RunE:         runCacheStats,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheIdentityCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+	AddFlagsFromSpec(cacheClearCmd, &cacheClearFlags, false)
+	AddFlagsFromSpec(cacheExportCmd, &cacheExportFlags, false)
+}
+
+// Open the cache selected with --cache-backend. The cache lifetime
+// determines which entries are considered expired.
+func openCache() (cache.Store, error) {
+	c, err := newCacheStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Start(); err != nil {
+		if errors.Is(err, cache.ErrKeyRequired) {
+			err = fmt.Errorf("%w (use --cache-key or KOLA_CACHE_KEY)", err)
+		}
+		return nil, err
+	}
+
+	if ro, ok := c.(interface{ ReadOnly() bool }); ok && ro.ReadOnly() {
+		log.Printf("warning: the cache is in use by another kola process; using a read-only copy")
+	}
+
+	if q, ok := c.(interface{ Quarantined() string }); ok && q.Quarantined() != "" {
+		log.Printf("warning: the cache was unreadable and has been moved to %s; starting with an empty cache", q.Quarantined())
+	}
+
+	return c, nil
+}
+
+// Return the abbreviated form of a bucket name.
+func shortBucketName(name string) string {
+	if len(name) > shortBucketNameLength {
+		return name[:shortBucketNameLength]
+	}
+	return name
+}
+
+// Format a size in bytes for humans.
+func formatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func bucketSize(bucket *cache.Bucket) int {
+	size := 0
+	for _, entry := range bucket.Entries {
+		size += entry.Size
+	}
+	return size
+}
+
+func runCacheList(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache list: %w", err)
+		}
+	}()
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		return err
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer out.Flush()
+
+	for _, bucket := range buckets {
+		host := bucket.Host
+		if host == "" {
+			host = "(unknown host)"
+		}
+
+		fmt.Fprintf(out, "%s\t%s\t%d entries\t%s\n",
+			shortBucketName(bucket.Name), host, len(bucket.Entries), formatSize(bucketSize(&bucket)))
+
+		sort.Slice(bucket.Entries, func(i, j int) bool {
+			return bucket.Entries[i].Key < bucket.Entries[j].Key
+		})
+
+		for _, entry := range bucket.Entries {
+			age := "unknown"
+			if !entry.Timestamp.IsZero() {
+				age = time.Since(entry.Timestamp).Round(time.Second).String()
+			}
+
+			expired := ""
+			if entry.Expired {
+				expired = "expired"
+			}
+
+			fmt.Fprintf(out, "\t%s\t%s\t%s\t%s\n", entry.Key, age, formatSize(entry.Size), expired)
+		}
+	}
+
+	return nil
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache clear: %w", err)
+		}
+	}()
+
+	if len(args) == 0 && !cacheClearFlags.All {
+		return errors.New("specify buckets to clear or --all")
+	}
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		return err
+	}
+
+	if !cacheClearFlags.All {
+		if buckets, err = selectBuckets(buckets, args); err != nil {
+			return err
+		}
+	}
+
+	cleared := 0
+	for _, bucket := range buckets {
+		if err := c.DeleteBucket(bucket.Name); err != nil {
+			return err
+		}
+		cleared++
+
+		if rootFlags.Verbose > 0 {
+			log.Printf("cleared bucket %s (%s)", shortBucketName(bucket.Name), bucket.Host)
+		}
+	}
+
+	log.Printf("cleared %d buckets", cleared)
+	return nil
+}
+
+// Return the buckets selected by any of the given selectors. A selector
+// is a host, which selects every bucket for that host, or a bucket name
+// or a prefix of one, which must select exactly one bucket and (unless it
+// is the whole name) be at least minBucketPrefixLength characters long.
+// It is an error for a selector to select nothing.
+func selectBuckets(buckets []cache.Bucket, selectors []string) ([]cache.Bucket, error) {
+	selected := make(map[string]bool)
+
+	for _, selector := range selectors {
+		var matches []cache.Bucket
+		for _, bucket := range buckets {
+			if bucket.Name == selector || (bucket.Host != "" && bucket.Host == selector) {
+				matches = append(matches, bucket)
+			}
+		}
+
+		if len(matches) == 0 && len(selector) >= minBucketPrefixLength {
+			for _, bucket := range buckets {
+				if strings.HasPrefix(bucket.Name, selector) {
+					matches = append(matches, bucket)
+				}
+			}
+
+			if len(matches) > 1 {
+				var names []string
+				for _, bucket := range matches {
+					names = append(names, shortBucketName(bucket.Name))
+				}
+				return nil, fmt.Errorf("%s is ambiguous: it is a prefix of several cache buckets (%s)",
+					selector, strings.Join(names, ", "))
+			}
+		}
+
+		if len(matches) == 0 {
+			if len(selector) < minBucketPrefixLength {
+				return nil, fmt.Errorf("no cache bucket or host %s (abbreviated bucket names must have at least %d characters)",
+					selector, minBucketPrefixLength)
+			}
+			return nil, fmt.Errorf("no cache bucket matching %s", selector)
+		}
+
+		for _, bucket := range matches {
+			selected[bucket.Name] = true
+		}
+	}
+
+	var result []cache.Bucket
+	for _, bucket := range buckets {
+		if selected[bucket.Name] {
+			result = append(result, bucket)
+		}
+	}
+
+	return result, nil
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache prune: %w", err)
+		}
+	}()
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	pruned, err := c.Prune()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("deleted %d expired entries", pruned)
+
+	boltCache, ok := c.(*cache.BoltCache)
+	if !ok {
+		return nil
+	}
+
+	result, err := boltCache.Maintain()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range result.Evicted {
+		log.Printf("evicted bucket %s (%s)", shortBucketName(bucket.Name), bucket.Host)
+	}
+
+	for _, path := range result.Removed {
+		log.Printf("removed damaged database %s", path)
+	}
+
+	if result.Compacted {
+		log.Printf("compacted %s from %s to %s", boltCache.Path(),
+			formatSize(int(result.SizeBefore)), formatSize(int(result.SizeAfter)))
+	}
+
+	return nil
+}
+
+func runCacheExport(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache export: %w", err)
+		}
+	}()
+
+	path, selectors := args[0], args[1:]
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if enc, ok := c.(interface{ Encrypted() bool }); ok && enc.Encrypted() {
+		if !cacheExportFlags.Decrypt {
+			return errors.New("the cache is encrypted and archives are not; use --decrypt to export it anyway")
+		}
+		log.Printf("warning: writing the contents of the encrypted cache to an unencrypted archive")
+	}
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		return err
+	}
+
+	selected := buckets
+	if len(selectors) > 0 {
+		if selected, err = selectBuckets(buckets, selectors); err != nil {
+			return err
+		}
+	}
+
+	if len(selected) == 0 {
+		return errors.New("the cache is empty")
+	}
+
+	entries := 0
+	for _, bucket := range selected {
+		entries += len(bucket.Entries)
+	}
+
+	if path == "-" {
+		if err := cache.Export(c, os.Stdout, selected); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		err = cache.Export(c, f, selected)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			return err
+		}
+	}
+
+	if rootFlags.Verbose > 0 {
+		for _, bucket := range selected {
+			log.Printf("exported bucket %s (%s)", shortBucketName(bucket.Name), bucket.Host)
+		}
+	}
+
+	log.Printf("exported %d buckets (%d entries)", len(selected), entries)
+	return nil
+}
+
+func runCacheImport(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache import: %w", err)
+		}
+	}()
+
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buckets, err := cache.Import(c, in)
+	if err != nil {
+		return err
+	}
+
+	entries := 0
+	for _, bucket := range buckets {
+		entries += len(bucket.Entries)
+		if rootFlags.Verbose > 0 {
+			log.Printf("imported bucket %s (%s): %d entries",
+				shortBucketName(bucket.Name), bucket.Host, len(bucket.Entries))
+		}
+	}
+
+	log.Printf("imported %d buckets (%d entries)", len(buckets), entries)
+	return nil
+}
+
+func runCacheStats(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache stats: %w", err)
+		}
+	}()
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		return err
+	}
+
+	entries, expired, size := 0, 0, 0
+	for _, bucket := range buckets {
+		entries += len(bucket.Entries)
+		size += bucketSize(&bucket)
+		for _, entry := range bucket.Entries {
+			if entry.Expired {
+				expired++
+			}
+		}
+	}
+
+	fmt.Printf("Backend: %s\n", rootFlags.CacheBackend)
+	fmt.Printf("Location: %s\n", c.Location())
+
+	if boltCache, ok := c.(*cache.BoltCache); ok {
+		info, err := os.Stat(boltCache.Path())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("File size: %s\n", formatSize(int(info.Size())))
+		fmt.Printf("Encrypted: %t\n", boltCache.Encrypted())
+	}
+
+	fmt.Printf("Buckets: %d\n", len(buckets))
+	fmt.Printf("Entries: %d (%d expired)\n", entries, expired)
+	fmt.Printf("Data size: %s\n", formatSize(size))
+	fmt.Printf("Lifetime: %s\n", rootFlags.CacheLifetime)
+
+	return nil
+}
+
+func runCacheIdentity(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("cache identity: %w", err)
+		}
+	}()
+
+	contexts, err := selectedContexts(rootFlags.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer out.Flush()
+
+	for _, contextName := range contexts {
+		config, err := client.BuildConfigFromFlags("", rootFlags.Kubeconfig, contextName)
+		if err != nil {
+			return err
+		}
+
+		bucket := cacheBucketName(config.Host, kubeCacheKey(rootFlags.Kubeconfig, contextName, config)...)
+		fmt.Fprintf(out, "%s\t%s\t%s\n", shortBucketName(bucket), config.Host,
+			cacheIdentity(rootFlags.Kubeconfig, contextName, config))
+	}
+
+	return nil
+}
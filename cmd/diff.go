@@ -0,0 +1,164 @@
+/*
+Copyright © 2022 Lars Kellogg-Stedman <lars@oddbit.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"kola/packagemanager"
+	"os"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"github.com/spf13/cobra"
+)
+
+type (
+	DiffFlags struct {
+		CatalogSource string   `short:"c" help:"Only compare packages from matching catalog sources"`
+		MapCatalog    []string `help:"Compare packages from catalog source OLD in the old catalog with those from NEW (OLD=NEW, may be repeated)"`
+	}
+)
+
+var diffFlags = DiffFlags{}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff OLD [NEW]",
+	Short: "Compare two package catalogs",
+	Long: `Compare two package catalogs and report added and removed packages,
+added and removed channels, changed channel heads and changed default
+channels.
+
+Each catalog is one of:
+
+  PATH               a saved snapshot (e.g. from "kubectl get packagemanifests -o yaml")
+  file:PATH          same as PATH
+  catalog:DIR        a File-Based Catalog directory
+  index:PATH         a SQLite index.db file
+  registry:ADDR      an operator-registry gRPC server
+  context:NAME       the cluster selected by the named kubeconfig context
+  cache:HOST         the cached packages for HOST (or a cache bucket name)
+  cluster            the cluster selected by the current context
+
+If NEW is omitted, OLD is compared against the packages selected by the
+global flags (by default, the current cluster).
+
+Packages are matched by catalog source (and its namespace) and name.
+Catalogs read from catalog: and index: are named after the directory or
+file, so use --map-catalog to match them with a catalog source on a
+cluster, e.g. --map-catalog catalog=openshift-marketplace/community-operators.`,
+	RunE:         runDiff,
+	Args:         cobra.RangeArgs(1, 2),
+	SilenceUsage: true,
+}
+
+func (flags *DiffFlags) Validate() error {
+	for _, mapping := range flags.MapCatalog {
+		if oldName, newName, ok := strings.Cut(mapping, "="); !ok || oldName == "" || newName == "" {
+			return NewValidationError("Invalid catalog mapping (expected OLD=NEW)", mapping)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	AddFlagsFromSpec(diffCmd, &diffFlags, false)
+}
+
+func runDiff(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("diff: %w", err)
+		}
+	}()
+
+	var filters []packagemanager.PackageManifestFilter
+	if diffFlags.CatalogSource != "" {
+		filters = append(filters, packagemanager.MatchCatalogSource(diffFlags.CatalogSource))
+	}
+
+	oldPm, err := getPackageManagerForSpec(rootFlags.Kubeconfig, args[0])
+	if err != nil {
+		return err
+	}
+
+	var newPm *packagemanager.PackageManager
+	if len(args) > 1 {
+		newPm, err = getPackageManagerForSpec(rootFlags.Kubeconfig, args[1])
+	} else {
+		newPm, err = getCachedPackageManager(rootFlags.Kubeconfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	oldPkgs, err := oldPm.ListPackageManifests(cmd.Context(), filters...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	newPkgs, err := newPm.ListPackageManifests(cmd.Context(), filters...)
+	if err != nil {
+		return err
+	}
+
+	options := packagemanager.DiffOptions{CatalogMap: make(map[string]string)}
+	for _, mapping := range diffFlags.MapCatalog {
+		oldName, newName, _ := strings.Cut(mapping, "=")
+		options.CatalogMap[oldName] = newName
+	}
+
+	writeCatalogDiff(os.Stdout, options.Diff(oldPkgs, newPkgs))
+
+	return nil
+}
+
+func writeCatalogDiff(out io.Writer, diff *packagemanager.CatalogDiff) {
+	for _, name := range diff.AddedPackages {
+		fmt.Fprintf(out, "+ %s\n", name)
+	}
+
+	for _, name := range diff.RemovedPackages {
+		fmt.Fprintf(out, "- %s\n", name)
+	}
+
+	for _, pkgDiff := range diff.ChangedPackages {
+		fmt.Fprintf(out, "~ %s\n", pkgDiff.Package)
+
+		if pkgDiff.OldDefaultChannel != pkgDiff.NewDefaultChannel {
+			fmt.Fprintf(out, "    default channel: %s -> %s\n", pkgDiff.OldDefaultChannel, pkgDiff.NewDefaultChannel)
+		}
+
+		for _, channel := range pkgDiff.AddedChannels {
+			writeChannelChange(out, "+", channel)
+		}
+
+		for _, channel := range pkgDiff.RemovedChannels {
+			writeChannelChange(out, "-", channel)
+		}
+
+		for _, change := range pkgDiff.ChangedHeads {
+			fmt.Fprintf(out, "    channel %s: %s -> %s\n", change.Channel, change.OldCSV, change.NewCSV)
+		}
+	}
+}
+
+func writeChannelChange(out io.Writer, marker string, channel operators.PackageChannel) {
+	fmt.Fprintf(out, "    %s channel %s (%s)\n", marker, channel.Name, channel.CurrentCSV)
+}
@@ -30,6 +30,7 @@ import (
 
 type (
 	DumpFlags struct {
+		CatalogSource string `help:"Use package from this catalog source"`
 	}
 )
 
@@ -61,7 +62,7 @@ func runDump(cmd *cobra.Command, args []string) (err error) {
 	if err != nil {
 		return err
 	}
-	pkg, err := pm.GetPackageManifest(args[0])
+	pkg, err := pm.FindPackageManifest(cmd.Context(), args[0], dumpFlags.CatalogSource)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,228 @@
+/*
+Copyright © 2022 Lars Kellogg-Stedman <lars@oddbit.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"kola/packagemanager"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+type (
+	GraphFlags struct {
+		Channel string `short:"c" help:"Show graph for this channel instead of the default channel"`
+		From    string `short:"f" help:"Show the upgrade path from this CSV to the channel head"`
+		Output  string `short:"o" help:"Output format (text, dot, mermaid)" default:"text"`
+	}
+)
+
+var graphFlags = GraphFlags{}
+
+var validGraphOutputs = []string{
+	"text",
+	"dot",
+	"mermaid",
+}
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:          "graph",
+	Short:        "Show the upgrade graph for a package channel",
+	RunE:         runGraph,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+func (flags *GraphFlags) Validate() error {
+	if !slices.Contains(validGraphOutputs, flags.Output) {
+		return NewValidationError(
+			"Invalid output format",
+			flags.Output,
+		)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	AddFlagsFromSpec(graphCmd, &graphFlags, false)
+}
+
+func runGraph(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("graph: %w", err)
+		}
+	}()
+
+	pm, err := getCachedPackageManager(rootFlags.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	graph, err := pm.GetUpgradeGraph(cmd.Context(), args[0], graphFlags.Channel)
+	if err != nil {
+		return err
+	}
+
+	if graph.EdgesUnknown && graphFlags.From == "" {
+		log.Printf("warning: %v; showing the bundles in the channel without upgrades", packagemanager.ErrEdgesUnknown)
+	}
+
+	var path []string
+	if graphFlags.From != "" {
+		if path, err = graph.PathToHead(graphFlags.From); err != nil {
+			return err
+		}
+	}
+
+	switch graphFlags.Output {
+	case "dot":
+		writeGraphDot(os.Stdout, graph, path)
+	case "mermaid":
+		writeGraphMermaid(os.Stdout, graph, path)
+	default:
+		writeGraphText(os.Stdout, graph, path)
+	}
+
+	return nil
+}
+
+// Return true if the edge from -> to is part of path.
+func pathContainsEdge(path []string, from, to string) bool {
+	for i := 0; i < len(path)-1; i++ {
+		if path[i] == from && path[i+1] == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the names of all nodes in the graph, including those that are
+// only referenced by edges (e.g. a bundle replaced by the oldest entry in
+// a channel).
+func graphNodeNames(graph *packagemanager.UpgradeGraph) []string {
+	var names []string
+
+	for _, node := range graph.Nodes {
+		names = append(names, node.Name)
+	}
+
+	for _, edge := range graph.Edges {
+		for _, name := range []string{edge.From, edge.To} {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+func writeGraphText(out io.Writer, graph *packagemanager.UpgradeGraph, path []string) {
+	fmt.Fprintf(out, "Package: %s\n", graph.Package)
+	fmt.Fprintf(out, "Channel: %s\n", graph.Channel)
+	fmt.Fprintf(out, "Head: %s\n", graph.Head)
+
+	if path != nil {
+		hops := "hops"
+		if len(path) == 2 {
+			hops = "hop"
+		}
+		fmt.Fprintf(out, "Upgrade path (%d %s):\n", len(path)-1, hops)
+		fmt.Fprintf(out, "%s\n", strings.Join(path, " -> "))
+		return
+	}
+
+	if graph.EdgesUnknown {
+		fmt.Fprintf(out, "Bundles:\n")
+		for _, node := range graph.Nodes {
+			fmt.Fprintf(out, "- %s\n", node.Name)
+		}
+		return
+	}
+
+	fmt.Fprintf(out, "Upgrades:\n")
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(out, "- %s -> %s (%s)\n", edge.From, edge.To, edge.Type)
+	}
+}
+
+func writeGraphDot(out io.Writer, graph *packagemanager.UpgradeGraph, path []string) {
+	fmt.Fprintf(out, "digraph %q {\n", graph.Package+"/"+graph.Channel)
+	fmt.Fprintf(out, "  rankdir=LR;\n")
+
+	for _, name := range graphNodeNames(graph) {
+		attrs := ""
+		if name == graph.Head {
+			attrs = " [peripheries=2]"
+		}
+		fmt.Fprintf(out, "  %q%s;\n", name, attrs)
+	}
+
+	for _, edge := range graph.Edges {
+		attrs := fmt.Sprintf("label=%q", edge.Type)
+		if edge.Type != packagemanager.EdgeReplaces {
+			attrs += ", style=dashed"
+		}
+		if pathContainsEdge(path, edge.From, edge.To) {
+			attrs += ", color=blue, penwidth=2"
+		}
+		fmt.Fprintf(out, "  %q -> %q [%s];\n", edge.From, edge.To, attrs)
+	}
+
+	fmt.Fprintf(out, "}\n")
+}
+
+func writeGraphMermaid(out io.Writer, graph *packagemanager.UpgradeGraph, path []string) {
+	// Bundle names are not valid Mermaid node ids, so we assign each
+	// node a generated id and use the name as its label.
+	ids := make(map[string]string)
+
+	fmt.Fprintf(out, "graph LR\n")
+	for i, name := range graphNodeNames(graph) {
+		ids[name] = fmt.Sprintf("n%d", i)
+		if name == graph.Head {
+			fmt.Fprintf(out, "  %s((%q))\n", ids[name], name)
+		} else {
+			fmt.Fprintf(out, "  %s[%q]\n", ids[name], name)
+		}
+	}
+
+	var highlight []string
+	for i, edge := range graph.Edges {
+		arrow := "-->"
+		if edge.Type != packagemanager.EdgeReplaces {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(out, "  %s %s|%s| %s\n", ids[edge.From], arrow, edge.Type, ids[edge.To])
+
+		if pathContainsEdge(path, edge.From, edge.To) {
+			highlight = append(highlight, fmt.Sprintf("%d", i))
+		}
+	}
+
+	if len(highlight) > 0 {
+		fmt.Fprintf(out, "  linkStyle %s stroke:blue,stroke-width:2px\n", strings.Join(highlight, ","))
+	}
+}
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"kola/packagemanager"
 	"log"
+	"os"
+	"text/tabwriter"
 
 	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
 	"github.com/spf13/cobra"
 	"golang.org/x/exp/slices"
 )
@@ -32,8 +35,10 @@ type (
 		Description   string   `short:"d" help:"Match string in package description"`
 		InstallMode   string   `short:"m" help:"Match package supported install mode"`
 		Keyword       []string `short:"w" help:"Match package keyword"`
+		OwnedAPI      string   `short:"a" help:"Match packages that own an API (group/version/Kind or CRD name)"`
 		Certified     bool     `short:"C" help:"Match only certified packages"`
 		Glob          bool     `short:"g" help:"Arguments are glob patterns instead of substrings"`
+		Query         string   `short:"q" help:"Match packages using a query expression"`
 	}
 )
 
@@ -41,8 +46,17 @@ var listFlags = ListFlags{}
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
-	Use:          "list",
-	Short:        "List available packages",
+	Use:   "list",
+	Short: "List available packages",
+	Long: `List available packages.
+
+The --query option accepts a boolean expression combining terms of the
+form field:value with AND, OR, NOT and parentheses, for example:
+
+  keyword:gitops AND (catalog:community OR certified:true) AND NOT provider:acme
+
+Available fields are name, keyword, catalog, description, installmode,
+provider, api and certified. A term without a field matches the package name.`,
 	RunE:         runList,
 	SilenceUsage: true,
 }
@@ -65,62 +79,100 @@ func (flags *ListFlags) Validate() error {
 	return nil
 }
 
-func runList(cmd *cobra.Command, args []string) (err error) {
-	defer func() {
-		if err != nil {
-			err = fmt.Errorf("list: %w", err)
-		}
-	}()
-
-	pm, err := getCachedPackageManager(rootFlags.Kubeconfig)
-	if err != nil {
-		return err
-	}
-
+// Build the list of filters selected by the command line options. Package
+// names (or glob patterns) are taken from args.
+func (flags *ListFlags) Filters(cmd *cobra.Command, args []string) ([]packagemanager.PackageManifestFilter, error) {
 	var filters []packagemanager.PackageManifestFilter
 
 	if len(args) > 0 {
-		if listFlags.Glob {
+		if flags.Glob {
 			filters = append(filters, packagemanager.MatchPackageGlobs(args...))
 		} else {
 			filters = append(filters, packagemanager.MatchPackageSubstrings(args...))
 		}
 	}
 
-	if listFlags.CatalogSource != "" {
-		filters = append(filters, packagemanager.MatchCatalogSource(listFlags.CatalogSource))
+	if flags.CatalogSource != "" {
+		filters = append(filters, packagemanager.MatchCatalogSource(flags.CatalogSource))
 	}
 
-	if listFlags.Description != "" {
-		filters = append(filters, packagemanager.MatchDescription(listFlags.Description))
+	if flags.Description != "" {
+		filters = append(filters, packagemanager.MatchDescription(flags.Description))
 	}
 
-	if listFlags.InstallMode != "" {
-		filters = append(filters, packagemanager.MatchInstallMode(listFlags.InstallMode))
+	if flags.InstallMode != "" {
+		filters = append(filters, packagemanager.MatchInstallMode(flags.InstallMode))
 	}
 
-	if len(listFlags.Keyword) > 0 {
-		filters = append(filters, packagemanager.MatchKeywords(listFlags.Keyword))
+	if len(flags.Keyword) > 0 {
+		filters = append(filters, packagemanager.MatchKeywords(flags.Keyword))
+	}
+
+	if flags.OwnedAPI != "" {
+		filters = append(filters, packagemanager.MatchOwnedAPI(flags.OwnedAPI))
 	}
 
 	if cmd.Flags().Lookup("certified").Changed {
-		filters = append(filters, packagemanager.MatchCertified(listFlags.Certified))
+		filters = append(filters, packagemanager.MatchCertified(flags.Certified))
 	}
 
-	packages, err := pm.ListPackageManifests(filters...)
+	if flags.Query != "" {
+		filter, err := packagemanager.ParseQuery(flags.Query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+func runList(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("list: %w", err)
+		}
+	}()
+
+	pms, err := getCachedPackageManagers(rootFlags.Kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("found %d packages", len(packages))
+	filters, err := listFlags.Filters(cmd, args)
+	if err != nil {
+		return err
+	}
 
-	for _, pkg := range packages {
-		if rootFlags.Verbose > 1 {
-			fmt.Printf("%s/%s %s\n", pkg.Status.CatalogSource, pkg.Name, pkg.Status.Channels[0].CurrentCSVDesc.DisplayName)
-		} else if rootFlags.Verbose > 0 {
-			fmt.Printf("%s/%s\n", pkg.Status.CatalogSource, pkg.Name)
-		} else {
-			fmt.Printf("%s\n", pkg.Name)
+	results := queryClusters(pms, func(pm *packagemanager.PackageManager) ([]operators.PackageManifest, error) {
+		return pm.ListPackageManifests(cmd.Context(), filters...)
+	})
+	if err := checkClusterResults(results); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, result := range results {
+		count += len(result.Value)
+	}
+	log.Printf("found %d packages", count)
+
+	// When querying multiple clusters, prefix each line with the name
+	// of the cluster in which we found the package. When querying all
+	// namespaces, include the namespace of the catalog source.
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	defer out.Flush()
+
+	for _, result := range results {
+		for _, pkg := range result.Value {
+			prefix := linePrefix(len(results), result.Cluster, &pkg)
+			if rootFlags.Verbose > 1 {
+				fmt.Fprintf(out, "%s%s/%s %s\n", prefix, pkg.Status.CatalogSource, pkg.Name, pkg.Status.Channels[0].CurrentCSVDesc.DisplayName)
+			} else if rootFlags.Verbose > 0 {
+				fmt.Fprintf(out, "%s%s/%s\n", prefix, pkg.Status.CatalogSource, pkg.Name)
+			} else {
+				fmt.Fprintf(out, "%s%s\n", prefix, pkg.Name)
+			}
 		}
 	}
 
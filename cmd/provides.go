@@ -0,0 +1,95 @@
+/*
+Copyright © 2022 Lars Kellogg-Stedman <lars@oddbit.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"kola/packagemanager"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"github.com/spf13/cobra"
+)
+
+type (
+	ProvidesFlags struct {
+	}
+)
+
+var providesFlags = ProvidesFlags{}
+
+// providesCmd represents the provides command
+var providesCmd = &cobra.Command{
+	Use:   "provides API",
+	Short: "Find packages that own an API",
+	Long: `Find packages that own an API, either as a CustomResourceDefinition or
+as an APIService. The API may be specified as group/version/Kind,
+group/version, group/Kind, a CRD name (plural.group), or just a Kind.`,
+	RunE:         runProvides,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(providesCmd)
+	AddFlagsFromSpec(providesCmd, &providesFlags, false)
+}
+
+func runProvides(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("provides: %w", err)
+		}
+	}()
+
+	pms, err := getCachedPackageManagers(rootFlags.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	spec := packagemanager.ParseAPISpec(args[0])
+	results := queryClusters(pms, func(pm *packagemanager.PackageManager) ([]operators.PackageManifest, error) {
+		return pm.ListPackageManifests(cmd.Context(), packagemanager.MatchOwnedAPI(args[0]))
+	})
+	if err := checkClusterResults(results); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, result := range results {
+		count += len(result.Value)
+	}
+	log.Printf("found %d packages", count)
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	defer out.Flush()
+
+	for _, result := range results {
+		for _, pkg := range result.Value {
+			prefix := linePrefix(len(results), result.Cluster, &pkg)
+			for i, channel := range pkg.Status.Channels {
+				if spec.OwnedBy(&pkg.Status.Channels[i]) {
+					fmt.Fprintf(out, "%s%s/%s\t%s\t%s\n", prefix, pkg.Status.CatalogSource, pkg.Name, channel.Name, channel.CurrentCSV)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -17,29 +17,112 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"kola/packagemanager"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 type (
 	RootFlags struct {
 		Kubeconfig    string        `short:"k" help:"Path to kubernetes client configuration"`
+		Context       []string      `help:"Use the named kubeconfig context (may be repeated)"`
+		AllContexts   bool          `help:"Query all kubeconfig contexts"`
+		Namespace     string        `short:"n" default:"default" help:"Query packages visible in this namespace" envvar:"KOLA_NAMESPACE"`
+		AllNamespaces bool          `short:"A" help:"Query packages in all namespaces"`
 		Verbose       int           `subtype:"counter" short:"v" help:"Increase output verbosity"`
 		Debug         bool          `help:"Traceback on panic" hide:"true"`
 		CacheLifetime time.Duration `default:"10m" help:"Set cache lifetime" envvar:"KOLA_CACHE_LIFETIME"`
 		NoCache       bool          `help:"Disable local caching of results" envvar:"KOLA_NO_CACHE"`
+		CachePolicy   string        `default:"stale-on-error" help:"What to do with expired cache entries (strict, stale-on-error, stale-while-revalidate)" envvar:"KOLA_CACHE_POLICY"`
+		Offline       bool          `help:"Never contact the cluster; use cached results regardless of age" envvar:"KOLA_OFFLINE"`
+		CacheBackend  string        `default:"bolt" help:"Where to store cached results (bolt, file, redis)" envvar:"KOLA_CACHE_BACKEND"`
+		CacheServer   string        `default:"redis://localhost:6379" help:"Server URL for the redis cache backend" envvar:"KOLA_CACHE_SERVER"`
+		CacheLockWait time.Duration `default:"2s" help:"How long to wait for another kola process to release the cache before using a read-only copy" envvar:"KOLA_CACHE_LOCK_WAIT"`
+		CacheMaxSize  int           `default:"256" help:"Evict the least recently used clusters when the cache database grows beyond this many MiB (0 means no limit)" envvar:"KOLA_CACHE_MAX_SIZE"`
+		CacheMaxAge   time.Duration `default:"720h" help:"Evict clusters that have not been used for this long from the cache database (0 means never)" envvar:"KOLA_CACHE_MAX_AGE"`
+		CacheKey      string        `help:"Encrypt the cache with the key from env:VARIABLE, file:PATH or keyring:NAME" envvar:"KOLA_CACHE_KEY"`
+		CacheIdentity string        `help:"Cache results under this identity instead of one derived from the kubeconfig context and user" envvar:"KOLA_CACHE_IDENTITY"`
+		Timeout       time.Duration `help:"Give up on requests that take longer than this (0 means no limit)" envvar:"KOLA_TIMEOUT"`
+		FromFile      string        `help:"Read packages from a file or directory instead of a cluster" envvar:"KOLA_FROM_FILE"`
+		FromCatalog   string        `help:"Read packages from a File-Based Catalog directory instead of a cluster" envvar:"KOLA_FROM_CATALOG"`
+		FromIndex     string        `help:"Read packages from a SQLite index.db file instead of a cluster" envvar:"KOLA_FROM_INDEX"`
+		FromRegistry  string        `help:"Read packages from an operator-registry gRPC server (host:port) instead of a cluster" envvar:"KOLA_FROM_REGISTRY"`
+		FromCache     string        `help:"Read packages from the cache bucket for this host (or bucket name) without contacting a cluster" envvar:"KOLA_FROM_CACHE"`
 	}
 )
 
 var rootCmd = &cobra.Command{
-	Use:           "kola",
-	Short:         "Interact with OLM package manifests",
-	SilenceErrors: true,
+	Use:               "kola",
+	Short:             "Interact with OLM package manifests",
+	SilenceErrors:     true,
+	PersistentPreRunE: rootPreRun,
 }
 
-var rootFlags = RootFlags{}
+var (
+	rootFlags = RootFlags{}
+
+	// Cancels the context created by rootPreRun.
+	cancelTimeout context.CancelFunc = func() {}
+
+	validCachePolicies = map[string]packagemanager.CachePolicy{
+		"strict":                 packagemanager.CacheStrict,
+		"stale-on-error":         packagemanager.CacheStaleOnError,
+		"stale-while-revalidate": packagemanager.CacheStaleWhileRevalidate,
+	}
+
+	validCacheBackends = []string{
+		"bolt",
+		"file",
+		"redis",
+	}
+
+	ErrInterrupted = errors.New("interrupted")
+	ErrTimeout     = errors.New("timed out")
+)
+
+// Validate the global options and apply the --timeout option to the
+// context of the command being run.
+func rootPreRun(cmd *cobra.Command, args []string) error {
+	if _, ok := validCachePolicies[rootFlags.CachePolicy]; !ok {
+		return NewValidationError("Invalid cache policy", rootFlags.CachePolicy)
+	}
+
+	if !slices.Contains(validCacheBackends, rootFlags.CacheBackend) {
+		return NewValidationError("Invalid cache backend", rootFlags.CacheBackend)
+	}
+
+	if rootFlags.Offline && rootFlags.NoCache {
+		return NewValidationError("--offline requires the cache", "")
+	}
+
+	if rootFlags.CacheKey != "" && rootFlags.CacheBackend != "bolt" {
+		return NewValidationError("--cache-key requires the bolt cache backend", "")
+	}
+
+	if rootFlags.CacheMaxSize < 0 {
+		return NewValidationError("Invalid cache size", fmt.Sprint(rootFlags.CacheMaxSize))
+	}
+
+	if rootFlags.FromCache != "" && rootFlags.NoCache {
+		return NewValidationError("--from-cache requires the cache", "")
+	}
+
+	if rootFlags.Timeout > 0 {
+		var ctx context.Context
+		ctx, cancelTimeout = context.WithTimeout(cmd.Context(), rootFlags.Timeout)
+		cmd.SetContext(ctx)
+	}
+
+	return nil
+}
 
 func Execute() {
 	defer func() {
@@ -50,10 +133,28 @@ func Execute() {
 			}
 		}
 	}()
-	err := rootCmd.Execute()
-	if err != nil {
-		log.Fatalf("ERROR: %v", err)
+
+	// Cancel in-flight requests when the user hits Ctrl-C.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	waitForRefreshes()
+	closeCacheStore()
+	interrupted := ctx.Err() != nil
+	cancelTimeout()
+	stop()
+
+	switch {
+	case err == nil:
+		return
+	case interrupted:
+		// Exit with the conventional status for SIGINT.
+		log.Printf("ERROR: %v", ErrInterrupted)
+		os.Exit(130)
+	case errors.Is(err, context.DeadlineExceeded):
+		err = fmt.Errorf("%w after %s: %v", ErrTimeout, rootFlags.Timeout, err)
 	}
+
+	log.Fatalf("ERROR: %v", err)
 }
 
 func init() {
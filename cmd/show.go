@@ -29,6 +29,7 @@ import (
 
 type (
 	ShowFlags struct {
+		CatalogSource string `help:"Use package from this catalog source"`
 	}
 )
 
@@ -59,30 +60,39 @@ func runShow(cmd *cobra.Command, args []string) (err error) {
 		}
 	}()
 
-	pm, err := getCachedPackageManager(rootFlags.Kubeconfig)
+	pms, err := getCachedPackageManagers(rootFlags.Kubeconfig)
 	if err != nil {
 		return err
 	}
 
 	for _, pkgName := range args {
-		pkg, err := pm.GetPackageManifest(pkgName)
-		if err != nil {
+		results := queryClusters(pms, func(pm *packagemanager.PackageManager) (*packagemanager.Package, error) {
+			return pm.FindPackageManifest(cmd.Context(), pkgName, showFlags.CatalogSource)
+		})
+		if err := checkClusterResults(results); err != nil {
 			return err
 		}
-		if err := showPackage(pkg); err != nil {
-			return err
+
+		for _, result := range results {
+			if result.Err != nil {
+				continue
+			}
+			if err := showPackage(result.Cluster, result.Value); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func showPackage(pkg *packagemanager.Package) error {
+func showPackage(cluster string, pkg *packagemanager.Package) error {
 	data := struct {
+		Cluster string
 		Package *packagemanager.Package
 		Flags   *ShowFlags
 		Verbose int
-	}{pkg, &showFlags, rootFlags.Verbose}
+	}{cluster, pkg, &showFlags, rootFlags.Verbose}
 
 	tmpl, err := template.New("package").Parse(showTemplate)
 	if err != nil {
@@ -17,8 +17,12 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"kola/client"
 	"kola/packagemanager"
+	"log"
 	"os"
 	"strings"
 
@@ -27,7 +31,11 @@ import (
 	"github.com/spf13/cobra"
 	"golang.org/x/exp/slices"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/kubectl/pkg/scheme"
 )
@@ -36,11 +44,21 @@ type (
 	SubscribeFlags struct {
 		Channel             string   `short:"c" help:"Set channel for subscription"`
 		Approval            string   `short:"a" help:"Set install plan approval for subscription" default:"Automatic"`
-		Namespace           string   `short:"n" help:"Set namespace for subscription"`
+		Namespace           string   `short:"n" help:"Set namespace for subscription (and query packages visible in this namespace)"`
 		CreateNamespace     bool     `short:"N" help:"Create a namespace"`
 		CreateOperatorGroup bool     `short:"G" help:"Create an OperatorGroup"`
 		TargetNamespace     []string `short:"t" help:"Set a target namespace"`
 		Selector            []string `short:"l" help:"Set a namespace selector"`
+		CatalogSource       string   `help:"Use package from this catalog source"`
+		Apply               bool     `help:"Create or update the resources in the cluster (with server-side apply) instead of printing them"`
+		DryRun              string   `help:"With --apply, have the API server validate the resources without persisting them (none, server)" default:"none"`
+		ForceConflicts      bool     `help:"With --apply, take ownership of fields that another client has set instead of failing"`
+	}
+
+	// A resourceApplier creates or updates resources in a cluster (see
+	// client.Applier).
+	resourceApplier interface {
+		Apply(ctx context.Context, obj runtime.Object) (*unstructured.Unstructured, error)
 	}
 )
 
@@ -52,6 +70,11 @@ var validApprovals = []string{
 	"Manual",
 }
 
+var validDryRuns = []string{
+	"none",
+	"server",
+}
+
 // subscribeCmd represents the subscribe command
 var subscribeCmd = &cobra.Command{
 	Aliases:      []string{"sub"},
@@ -69,6 +92,22 @@ func (flags *SubscribeFlags) Validate() error {
 			flags.Approval,
 		)
 	}
+
+	if !slices.Contains(validDryRuns, flags.DryRun) {
+		return NewValidationError(
+			"Invalid dry run mode",
+			flags.DryRun,
+		)
+	}
+
+	if flags.DryRun != "none" && !flags.Apply {
+		return NewValidationError("--dry-run requires --apply", "")
+	}
+
+	if flags.ForceConflicts && !flags.Apply {
+		return NewValidationError("--force-conflicts requires --apply", "")
+	}
+
 	return nil
 }
 
@@ -84,24 +123,38 @@ func runSubscribe(cmd *cobra.Command, args []string) (err error) {
 		}
 	}()
 
+	// Our --namespace option shadows the global option of the same name.
+	// A subscription can only use catalog sources that are visible in
+	// its own namespace, so that is where we look for packages.
+	if subscribeFlags.Namespace != "" {
+		rootFlags.Namespace = subscribeFlags.Namespace
+		rootFlags.AllNamespaces = false
+	}
+
+	if subscribeFlags.Apply {
+		if err := checkApplyTarget(); err != nil {
+			return err
+		}
+	}
+
 	pm, err := getCachedPackageManager(rootFlags.Kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	pkg, err := pm.GetPackageManifest(args[0])
+	pkg, err := pm.FindPackageManifest(cmd.Context(), args[0], subscribeFlags.CatalogSource)
 	if err != nil {
 		return err
 	}
 
-	if err := subscribePackage(pkg); err != nil {
+	if err := subscribePackage(cmd.Context(), pkg); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func subscribePackage(pkg *packagemanager.Package) error {
+func subscribePackage(ctx context.Context, pkg *packagemanager.Package) error {
 	channelName := subscribeFlags.Channel
 	if channelName == "" {
 		channelName = pkg.GetDefaultChannelName()
@@ -114,11 +167,22 @@ func subscribePackage(pkg *packagemanager.Package) error {
 
 	namespaceName := subscribeFlags.Namespace
 	if namespaceName == "" {
-		if suggested, ok := channel.CurrentCSVDesc.Annotations["operatorframework.io/suggested-namespace"]; ok {
+		catalogNamespace := pkg.Status.CatalogSourceNamespace
+		if catalogNamespace != "" && catalogNamespace == packageNamespace() {
+			// The package comes from a catalog source in the namespace
+			// we queried rather than from a global catalog source, so
+			// the subscription must live in the same namespace.
+			namespaceName = catalogNamespace
+		} else if suggested, ok := channel.CurrentCSVDesc.Annotations["operatorframework.io/suggested-namespace"]; ok {
 			namespaceName = suggested
 		}
 	}
 
+	if rootFlags.AllNamespaces && namespaceName != pkg.Status.CatalogSourceNamespace {
+		log.Printf("warning: catalog source %s is in namespace %s; the subscription can only use it if that is the global catalog namespace",
+			pkg.Status.CatalogSource, pkg.Status.CatalogSourceNamespace)
+	}
+
 	if subscribeFlags.CreateOperatorGroup {
 		if len(subscribeFlags.TargetNamespace) == 0 && !pkg.SupportsInstallMode("AllNamespaces") {
 			return fmt.Errorf("%s does not support AllNamespaces install mode", pkg.Name)
@@ -149,22 +213,7 @@ func subscribePackage(pkg *packagemanager.Package) error {
 		},
 	}
 
-	//nolint:errcheck
-	operatorsv1alpha1.AddToScheme(scheme.Scheme)
-	//nolint:errcheck
-	corev1.AddToScheme(scheme.Scheme)
-
-	serializer := json.NewSerializerWithOptions(
-		json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
-		json.SerializerOptions{
-			Pretty: true,
-			Yaml:   true,
-			Strict: true,
-		})
-
-	if err := serializer.Encode(&subscription, os.Stdout); err != nil {
-		return err
-	}
+	var resources []runtime.Object
 
 	if subscribeFlags.CreateNamespace {
 		namespace := corev1.Namespace{
@@ -176,10 +225,7 @@ func subscribePackage(pkg *packagemanager.Package) error {
 				Name: namespaceName,
 			},
 		}
-		os.Stdout.Write([]byte("---\n"))
-		if err := serializer.Encode(&namespace, os.Stdout); err != nil {
-			return err
-		}
+		resources = append(resources, &namespace)
 	}
 
 	if subscribeFlags.CreateOperatorGroup {
@@ -207,12 +253,143 @@ func subscribePackage(pkg *packagemanager.Package) error {
 			}
 		}
 
-		os.Stdout.Write([]byte("---\n"))
-		if err := serializer.Encode(&operatorgroup,
-			os.Stdout); err != nil {
+		resources = append(resources, &operatorgroup)
+	}
+
+	if subscribeFlags.Apply {
+		if namespaceName == "" {
+			return fmt.Errorf("%s does not suggest a namespace; use --namespace to choose one", pkg.Name)
+		}
+
+		// The namespace and operator group must exist before the
+		// subscription.
+		return applyResources(ctx, append(resources, &subscription))
+	}
+
+	return printResources(append([]runtime.Object{&subscription}, resources...))
+}
+
+// With --apply we create resources in the cluster we read packages from,
+// so there must be exactly one, and it must be a cluster.
+func checkApplyTarget() error {
+	if rootFlags.FromFile != "" || rootFlags.FromCatalog != "" || rootFlags.FromIndex != "" ||
+		rootFlags.FromRegistry != "" || rootFlags.FromCache != "" {
+		return errors.New("--apply can't be used with --from-file, --from-catalog, --from-index, --from-registry or --from-cache")
+	}
+
+	if rootFlags.AllContexts || len(rootFlags.Context) > 1 {
+		return errors.New("--apply can only be used with a single context")
+	}
+
+	return nil
+}
+
+// Print resources as a YAML stream.
+func printResources(resources []runtime.Object) error {
+	//nolint:errcheck
+	operatorsv1alpha1.AddToScheme(scheme.Scheme)
+	//nolint:errcheck
+	corev1.AddToScheme(scheme.Scheme)
+
+	serializer := json.NewSerializerWithOptions(
+		json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+		json.SerializerOptions{
+			Pretty: true,
+			Yaml:   true,
+			Strict: true,
+		})
+
+	for i, obj := range resources {
+		if i > 0 {
+			os.Stdout.Write([]byte("---\n"))
+		}
+		if err := serializer.Encode(obj, os.Stdout); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// Return the kind and name of a resource, e.g. "subscription/flux".
+func resourceName(obj runtime.Object) string {
+	kind := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind)
+	if accessor, err := meta.Accessor(obj); err == nil {
+		return kind + "/" + accessor.GetName()
+	}
+
+	return kind
+}
+
+// Apply resources to the cluster, in order, and report the result for
+// each. We carry on after a failure so that every problem is reported.
+func applyResources(ctx context.Context, resources []runtime.Object) error {
+	// checkApplyTarget ensures there is at most one context.
+	var contextName string
+	if len(rootFlags.Context) > 0 {
+		contextName = rootFlags.Context[0]
+	}
+
+	applier, err := client.NewApplier(rootFlags.Kubeconfig, contextName)
+	if err != nil {
+		return err
+	}
+
+	dryRun := subscribeFlags.DryRun == "server"
+	applier.WithDryRun(dryRun).WithForce(subscribeFlags.ForceConflicts)
+
+	return applyEach(ctx, applier, resources, dryRun)
+}
+
+// Apply each resource with applier. In a server dry run, a resource in a
+// namespace that we would have created is reported as not validated
+// rather than failed.
+func applyEach(ctx context.Context, applier resourceApplier, resources []runtime.Object, dryRun bool) error {
+	result := "applied"
+	if dryRun {
+		result = "applied (server dry run)"
+	}
+
+	// Namespaces we would have created, were this not a dry run.
+	created := make(map[string]bool)
+
+	failed := 0
+	for _, obj := range resources {
+		name := resourceName(obj)
+
+		if _, err := applier.Apply(ctx, obj); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+
+			// The API server can't validate a resource in a namespace
+			// that a dry run didn't create.
+			if status, ok := err.(apierrors.APIStatus); ok && apierrors.IsNotFound(err) {
+				if details := status.Status().Details; details != nil && details.Kind == "namespaces" && created[details.Name] {
+					fmt.Printf("%s not validated (namespace %s does not exist yet)\n", name, details.Name)
+					continue
+				}
+			}
+
+			failed++
+			if apierrors.IsConflict(err) {
+				log.Printf("%s: %v (use --force-conflicts to take ownership of these fields)", name, err)
+			} else {
+				log.Printf("%s: %v", name, err)
+			}
+			continue
+		}
+
+		if namespace, ok := obj.(*corev1.Namespace); ok && dryRun {
+			created[namespace.Name] = true
+		}
+
+		fmt.Printf("%s %s\n", name, result)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to apply %d of %d resources", failed, len(resources))
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// A resourceApplier for a cluster with no namespaces: as in a server dry
+// run, namespaces appear to be created, but nothing can be created in
+// them.
+type noNamespacesApplier struct{}
+
+func (noNamespacesApplier) Apply(ctx context.Context, obj runtime.Object) (*unstructured.Unstructured, error) {
+	if _, ok := obj.(*corev1.Namespace); ok {
+		return &unstructured.Unstructured{}, nil
+	}
+
+	namespace := obj.(metav1.Object).GetNamespace()
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, namespace)
+}
+
+func TestApplyEach(t *testing.T) {
+	namespace := &corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+	}
+	subscription := func(namespace string) *operatorsv1alpha1.Subscription {
+		return &operatorsv1alpha1.Subscription{
+			TypeMeta:   metav1.TypeMeta{APIVersion: operatorsv1alpha1.SchemeGroupVersion.String(), Kind: "Subscription"},
+			ObjectMeta: metav1.ObjectMeta{Name: "flux", Namespace: namespace},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		resources []runtime.Object
+		dryRun    bool
+		expected  string
+	}{
+		{"dry run in a new namespace", []runtime.Object{namespace, subscription("team")}, true, ""},
+		{"dry run in a missing namespace", []runtime.Object{namespace, subscription("other")}, true, "failed to apply 1 of 2 resources"},
+		{"apply in a missing namespace", []runtime.Object{namespace, subscription("team")}, false, "failed to apply 1 of 2 resources"},
+	} {
+		err := applyEach(context.Background(), noNamespacesApplier{}, test.resources, test.dryRun)
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
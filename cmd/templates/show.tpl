@@ -1,5 +1,9 @@
+{{ if .Cluster }}Cluster: {{ .Cluster }}
+{{ end -}}
 Name: {{ .Package.Name }}
 Catalog source: {{ .Package.Status.CatalogSourceDisplayName }} ({{ .Package.Status.CatalogSource }})
+{{ if .Package.Status.CatalogSourceNamespace }}Catalog namespace: {{ .Package.Status.CatalogSourceNamespace }}
+{{ end -}}
 Publisher: {{ .Package.Status.CatalogSourcePublisher }}
 Provider: {{ .Package.Status.Provider.Name }}{{ if .Package.Status.Provider.URL }} ({{ .Package.Status.Provider.URL }}){{ end }}
 Keywords:
@@ -2,39 +2,427 @@ package cmd
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"kola/cache"
 	"kola/client"
 	"kola/packagemanager"
 	"log"
+	"strings"
+	"sync"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"k8s.io/client-go/rest"
 )
 
+type (
+	// A PackageManager associated with a particular cluster (identified
+	// by the name of a kubeconfig context). Cluster is empty when using
+	// the current context or a non-Kubernetes package source.
+	clusterPackageManager struct {
+		Cluster string
+		*packagemanager.PackageManager
+	}
+
+	// The result of running a query against a single cluster.
+	clusterResult[T any] struct {
+		Cluster string
+		Value   T
+		Err     error
+	}
+)
+
+// All clusters share a single cache Store (each in its own bucket). For
+// the bolt backend this is necessary because bolt holds an exclusive lock
+// on the database file.
+var cacheStore cache.Store
+
+// Package managers with a cache, which may be refreshing cached values in
+// the background.
+var cachedPackageManagers []*packagemanager.PackageManager
+
 // Return a new PackageManager with an associated Cache (unless --no-cache
-// was specified at runtime).
+// was specified at runtime). This is for commands that operate on a single
+// cluster; it is an error to select more than one context.
 func getCachedPackageManager(kubeconfig string) (*packagemanager.PackageManager, error) {
-	config, clientset, err := client.GetClient(kubeconfig)
+	pms, err := getCachedPackageManagers(kubeconfig)
 	if err != nil {
 		return nil, err
 	}
 
-	pm := packagemanager.NewPackageManager(clientset)
+	if len(pms) > 1 {
+		return nil, errors.New("this command does not support multiple contexts")
+	}
+
+	return pms[0].PackageManager, nil
+}
+
+// Return a PackageManager for each context selected with --context or
+// --all-contexts, or for the current context if neither was specified. If
+// --from-file, --from-catalog or --from-index was specified, packages are
+// read from local files and no cache is used. If --from-cache was
+// specified, packages are read only from the cache.
+func getCachedPackageManagers(kubeconfig string) ([]clusterPackageManager, error) {
+	var pm *packagemanager.PackageManager
+
+	switch {
+	case rootFlags.FromCache != "":
+		var err error
+		if pm, err = getCachePackageManager(rootFlags.FromCache); err != nil {
+			return nil, err
+		}
+	case rootFlags.FromFile != "":
+		pm = getSourcePackageManager("file", rootFlags.FromFile)
+	case rootFlags.FromCatalog != "":
+		pm = getSourcePackageManager("catalog", rootFlags.FromCatalog)
+	case rootFlags.FromIndex != "":
+		pm = getSourcePackageManager("index", rootFlags.FromIndex)
+	case rootFlags.FromRegistry != "":
+		pm = getSourcePackageManager("registry", rootFlags.FromRegistry)
+	}
+
+	if pm != nil {
+		return []clusterPackageManager{{PackageManager: pm}}, nil
+	}
+
+	contexts, err := selectedContexts(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contexts) == 0 {
+		pm, err := getKubePackageManager(kubeconfig, "")
+		if err != nil {
+			return nil, err
+		}
+		return []clusterPackageManager{{PackageManager: pm}}, nil
+	}
+
+	var pms []clusterPackageManager
+	for _, contextName := range contexts {
+		pm, err := getKubePackageManager(kubeconfig, contextName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", contextName, err)
+		}
+		pms = append(pms, clusterPackageManager{Cluster: contextName, PackageManager: pm})
+	}
+
+	return pms, nil
+}
+
+// Return the contexts selected with --context or --all-contexts, or nil
+// to use the current context.
+func selectedContexts(kubeconfig string) ([]string, error) {
+	if rootFlags.AllContexts {
+		return client.GetContexts(kubeconfig)
+	}
+
+	return rootFlags.Context, nil
+}
+
+// Return a PackageManager for a non-Kubernetes package source ("file",
+// "catalog", "index" or "registry"), or nil if kind is not one of those.
+func getSourcePackageManager(kind, location string) *packagemanager.PackageManager {
+	switch kind {
+	case "file":
+		return packagemanager.NewPackageManager(
+			packagemanager.NewFileSource(location))
+	case "catalog":
+		return packagemanager.NewPackageManager(
+			packagemanager.NewFBCSource(location))
+	case "index":
+		return packagemanager.NewPackageManager(
+			packagemanager.NewSQLiteSource(location))
+	case "registry":
+		return withCache(packagemanager.NewPackageManager(
+			packagemanager.NewRegistrySource(location)),
+			"grpc://"+location)
+	}
+
+	return nil
+}
+
+// Return a PackageManager for a package source specification of the form
+// "kind:location", where kind is one of the kinds accepted by
+// getSourcePackageManager or "context" (in which case location is the name
+// of a kubeconfig context). The specification "cluster" refers to the
+// current context, and anything else is treated as the path to a file.
+func getPackageManagerForSpec(kubeconfig, spec string) (*packagemanager.PackageManager, error) {
+	if spec == "cluster" {
+		return getKubePackageManager(kubeconfig, "")
+	}
+
+	kind, location, found := strings.Cut(spec, ":")
+	if !found {
+		return getSourcePackageManager("file", spec), nil
+	}
+
+	if kind == "context" {
+		return getKubePackageManager(kubeconfig, location)
+	}
+
+	if kind == "cache" {
+		return getCachePackageManager(location)
+	}
 
-	if !rootFlags.NoCache {
+	if pm := getSourcePackageManager(kind, location); pm != nil {
+		return pm, nil
+	}
 
-		// Generate a hash of (Host, APIPath) to use as a cache
-		// identifier. This ensures we don't accidentally use
-		// cached information for the wrong remote host.
-		hash := sha256.New()
-		hash.Write([]byte(config.Host))
-		hash.Write([]byte(config.APIPath))
+	return getSourcePackageManager("file", spec), nil
+}
 
-		cache := cache.NewCache("kola", fmt.Sprintf("%x", hash.Sum(nil))).
-			WithLifetime(rootFlags.CacheLifetime)
-		if err := cache.Start(); err != nil {
-			log.Printf("failed to start cache: %v", err)
-		} else {
-			pm = pm.WithCache(cache)
+// Return a PackageManager that reads packages only from the cache bucket
+// selected by host or by (a prefix of) the bucket name, without
+// contacting the cluster. This is how we use a cache imported from
+// another machine.
+func getCachePackageManager(selector string) (*packagemanager.PackageManager, error) {
+	store, err := getCacheStore()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := store.Buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := selectBuckets(buckets, []string{selector})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) > 1 {
+		var names []string
+		for _, bucket := range matches {
+			names = append(names, shortBucketName(bucket.Name))
 		}
+		return nil, fmt.Errorf("%s matches several cache buckets (%s); select one by name",
+			selector, strings.Join(names, ", "))
 	}
-	return pm, nil
+
+	c, err := store.Bucket(matches[0].Name, matches[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return packagemanager.NewPackageManager(&packagemanager.NullSource{}).
+		WithCachePolicy(packagemanager.CacheOffline).
+		WithCache(c), nil
+}
+
+// Return the namespace in which to query PackageManifests, or an empty
+// string if --all-namespaces was specified.
+func packageNamespace() string {
+	if rootFlags.AllNamespaces {
+		return ""
+	}
+
+	return rootFlags.Namespace
+}
+
+// Return a PackageManager that reads packages from the cluster selected
+// by the given kubeconfig context.
+func getKubePackageManager(kubeconfig, contextName string) (*packagemanager.PackageManager, error) {
+	config, clientset, err := client.GetClient(kubeconfig, contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := packageNamespace()
+	pm := packagemanager.NewPackageManager(
+		packagemanager.NewKubeSource(clientset).WithNamespace(namespace))
+	return withCache(pm, config.Host, kubeCacheKey(kubeconfig, contextName, config)...), nil
+}
+
+// Return the strings that, along with the host, identify the cache bucket
+// for a cluster.
+func kubeCacheKey(kubeconfig, contextName string, config *rest.Config) []string {
+	return []string{
+		config.APIPath,
+		packageNamespace(),
+		cacheIdentity(kubeconfig, contextName, config),
+	}
+}
+
+// Return the identity under which results from a cluster are cached:
+// the one given with --cache-identity, or else one derived from the
+// kubeconfig context and user, so that users who may see different
+// packages don't share cached results.
+func cacheIdentity(kubeconfig, contextName string, config *rest.Config) string {
+	if rootFlags.CacheIdentity != "" {
+		return rootFlags.CacheIdentity
+	}
+
+	return client.GetIdentity(kubeconfig, contextName, config).String()
+}
+
+// Return the name of the cache bucket for the given host and any other
+// identifying strings.
+func cacheBucketName(host string, identity ...string) string {
+	// Generate a hash of the identity (e.g. Host and APIPath) to use
+	// as a cache identifier. This ensures we don't accidentally use
+	// cached information for the wrong remote host.
+	hash := sha256.New()
+	for _, s := range append([]string{host}, identity...) {
+		hash.Write([]byte(s))
+		hash.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Attach a Cache to pm (unless --no-cache was specified at runtime). The
+// cache bucket is named using a hash of the host and the given identity
+// strings, and records the host to which it belongs.
+func withCache(pm *packagemanager.PackageManager, host string, identity ...string) *packagemanager.PackageManager {
+	if rootFlags.NoCache {
+		return pm
+	}
+
+	cacheName := cacheBucketName(host, identity...)
+
+	policy := validCachePolicies[rootFlags.CachePolicy]
+	if rootFlags.Offline {
+		policy = packagemanager.CacheOffline
+	}
+	pm.WithCachePolicy(policy)
+	cachedPackageManagers = append(cachedPackageManagers, pm)
+
+	store, err := getCacheStore()
+	if err != nil {
+		log.Printf("warning: unable to open the cache (%v); continuing without it", err)
+		return pm
+	}
+
+	cache, err := store.Bucket(cacheName, host)
+	if err != nil {
+		log.Printf("failed to start cache: %v", err)
+		return pm
+	}
+	return pm.WithCache(cache)
+}
+
+// Return the cache Store selected with --cache-backend. The Store must be
+// started before use.
+func newCacheStore() (cache.Store, error) {
+	switch rootFlags.CacheBackend {
+	case "file":
+		return cache.NewFileCache("kola", "").
+			WithLifetime(rootFlags.CacheLifetime), nil
+	case "redis":
+		return cache.NewRedisCache(rootFlags.CacheServer, "kola", "").
+			WithLifetime(rootFlags.CacheLifetime), nil
+	}
+
+	store := cache.NewCache("kola", "").
+		WithLifetime(rootFlags.CacheLifetime).
+		WithOpenTimeout(rootFlags.CacheLockWait).
+		WithMaxSize(int64(rootFlags.CacheMaxSize) << 20).
+		WithMaxAge(rootFlags.CacheMaxAge)
+
+	if rootFlags.CacheKey != "" {
+		key, err := cache.LoadKey(rootFlags.CacheKey)
+		if err != nil {
+			return nil, err
+		}
+		store.WithEncryptionKey(key)
+	}
+
+	return store, nil
+}
+
+// Return the cache Store shared by all clusters, starting it if
+// necessary.
+func getCacheStore() (cache.Store, error) {
+	if cacheStore == nil {
+		store, err := openCache()
+		if err != nil {
+			return nil, err
+		}
+		cacheStore = store
+	}
+
+	return cacheStore, nil
+}
+
+// Close the shared cache Store, if it was started.
+func closeCacheStore() {
+	if cacheStore == nil {
+		return
+	}
+
+	if err := cacheStore.Close(); err != nil {
+		log.Printf("warning: failed to close cache: %v", err)
+	}
+	cacheStore = nil
+}
+
+// Wait for any background cache refreshes to complete.
+func waitForRefreshes() {
+	for _, pm := range cachedPackageManagers {
+		pm.Wait()
+	}
+}
+
+// Run query concurrently against each PackageManager and return the
+// results in the same order as pms.
+func queryClusters[T any](pms []clusterPackageManager, query func(pm *packagemanager.PackageManager) (T, error)) []clusterResult[T] {
+	var wg sync.WaitGroup
+
+	results := make([]clusterResult[T], len(pms))
+	for i, pm := range pms {
+		wg.Add(1)
+		go func(i int, pm clusterPackageManager) {
+			defer wg.Done()
+			value, err := query(pm.PackageManager)
+			results[i] = clusterResult[T]{Cluster: pm.Cluster, Value: value, Err: err}
+		}(i, pm)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Return the columns that precede a package in tabular output: the name
+// of the cluster when querying multiple clusters, and the namespace of the
+// catalog source when querying all namespaces.
+func linePrefix(clusters int, cluster string, pkg *operators.PackageManifest) string {
+	prefix := ""
+	if clusters > 1 {
+		prefix += cluster + "\t"
+	}
+	if rootFlags.AllNamespaces {
+		prefix += pkg.Status.CatalogSourceNamespace + "\t"
+	}
+
+	return prefix
+}
+
+// Check the results of queryClusters. When querying multiple clusters,
+// errors from individual clusters are logged and ignored unless every
+// cluster failed, in which case we return the first error.
+func checkClusterResults[T any](results []clusterResult[T]) error {
+	var firstErr error
+	failed := 0
+
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+
+		failed++
+		if firstErr == nil {
+			firstErr = result.Err
+		}
+		if len(results) > 1 {
+			log.Printf("%s: %v", result.Cluster, result.Err)
+		}
+	}
+
+	if failed == len(results) {
+		return firstErr
+	}
+
+	return nil
 }
@@ -0,0 +1,159 @@
+/*
+Copyright © 2022 Lars Kellogg-Stedman <lars@oddbit.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kola/packagemanager"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+type (
+	WatchFlags struct {
+		Interval time.Duration `short:"i" help:"Polling interval when the server does not support watch" default:"1m"`
+		Output   string        `short:"o" help:"Output format (text, json)" default:"text"`
+	}
+
+	// A PackageEvent as written in JSON lines output.
+	watchEvent struct {
+		Time    time.Time `json:"time"`
+		Cluster string    `json:"cluster,omitempty"`
+		packagemanager.PackageEvent
+	}
+)
+
+var (
+	watchFlags = WatchFlags{}
+
+	// watch accepts the same filters as list.
+	watchFilterFlags = ListFlags{}
+
+	validWatchOutputs = []string{
+		"text",
+		"json",
+	}
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch for changes to available packages",
+	Long: `Watch for changes to available packages and report packages that are
+added or removed, channels that are added or removed, channel heads that
+move and default channels that change.
+
+Packages may be selected using the same options as the list command.`,
+	RunE:         runWatch,
+	SilenceUsage: true,
+}
+
+func (flags *WatchFlags) Validate() error {
+	if !slices.Contains(validWatchOutputs, flags.Output) {
+		return NewValidationError(
+			"Invalid output format",
+			flags.Output,
+		)
+	}
+	return watchFilterFlags.Validate()
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	AddFlagsFromSpec(watchCmd, &watchFilterFlags, false)
+	AddFlagsFromSpec(watchCmd, &watchFlags, false)
+}
+
+func runWatch(cmd *cobra.Command, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("watch: %w", err)
+		}
+	}()
+
+	if rootFlags.Offline {
+		return errors.New("cannot watch in offline mode")
+	}
+
+	if rootFlags.FromCache != "" {
+		return errors.New("cannot watch cached packages")
+	}
+
+	pms, err := getCachedPackageManagers(rootFlags.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	filters, err := watchFilterFlags.Filters(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	encoder := json.NewEncoder(os.Stdout)
+
+	writeEvent := func(cluster string, event packagemanager.PackageEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		if watchFlags.Output == "json" {
+			return encoder.Encode(watchEvent{now, cluster, event})
+		}
+
+		prefix := ""
+		if len(pms) > 1 {
+			prefix = cluster + ": "
+		}
+		_, err := fmt.Printf("%s %s%s\n", now.Format(time.RFC3339), prefix, event)
+		return err
+	}
+
+	// Watch every cluster until we are interrupted. A cluster that fails
+	// doesn't stop us from watching the others.
+	errs := make([]error, len(pms))
+	var wg sync.WaitGroup
+
+	for i, pm := range pms {
+		wg.Add(1)
+		go func(i int, pm clusterPackageManager) {
+			defer wg.Done()
+			errs[i] = pm.Watch(cmd.Context(), watchFlags.Interval, func(event packagemanager.PackageEvent) error {
+				return writeEvent(pm.Cluster, event)
+			}, filters...)
+			if len(pms) > 1 && cmd.Context().Err() == nil {
+				log.Printf("%s: %v", pm.Cluster, errs[i])
+			}
+		}(i, pm)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
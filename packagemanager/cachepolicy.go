@@ -0,0 +1,82 @@
+package packagemanager
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+type (
+	// A CachePolicy determines what we do when a cached value has
+	// expired.
+	CachePolicy int
+
+	// Background refreshes started by the CacheStaleWhileRevalidate
+	// policy.
+	refreshes struct {
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		inFlight map[string]bool
+	}
+)
+
+const (
+	// Fetch expired values from the Source. If that fails, return the
+	// expired value (with a warning) rather than an error.
+	CacheStaleOnError CachePolicy = iota
+
+	// Fetch expired values from the Source and fail if that fails.
+	CacheStrict
+
+	// Return expired values immediately (with a warning) and refresh
+	// them in the background. Call Wait to wait for the refresh to
+	// complete.
+	CacheStaleWhileRevalidate
+
+	// Never contact the Source; return cached values regardless of age.
+	CacheOffline
+)
+
+// Returned in offline mode when a value is not in the cache.
+var ErrNotCached = errors.New("not available in offline mode")
+
+// Set the cache policy.
+func (pm *PackageManager) WithCachePolicy(policy CachePolicy) *PackageManager {
+	pm.cachePolicy = policy
+	return pm
+}
+
+// Run fn in the background, unless there is already a refresh of key in
+// progress.
+func (pm *PackageManager) refresh(ctx context.Context, key string, fn func(ctx context.Context) error) {
+	pm.refreshes.mu.Lock()
+	defer pm.refreshes.mu.Unlock()
+
+	if pm.refreshes.inFlight == nil {
+		pm.refreshes.inFlight = make(map[string]bool)
+	}
+
+	if pm.refreshes.inFlight[key] {
+		return
+	}
+	pm.refreshes.inFlight[key] = true
+
+	pm.refreshes.wg.Add(1)
+	go func() {
+		defer pm.refreshes.wg.Done()
+
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to refresh %s: %v", key, err)
+		}
+
+		pm.refreshes.mu.Lock()
+		delete(pm.refreshes.inFlight, key)
+		pm.refreshes.mu.Unlock()
+	}()
+}
+
+// Wait for any background refreshes to complete.
+func (pm *PackageManager) Wait() {
+	pm.refreshes.wg.Wait()
+}
@@ -0,0 +1,185 @@
+package packagemanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"kola/cache"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+// A Source that returns the "flux" package with a channel head of
+// "new", or fails if err is set. If release is set, it waits for
+// release to be closed before answering.
+type policySource struct {
+	NullSource
+
+	err     error
+	release chan struct{}
+
+	mu      sync.Mutex
+	fetches int
+}
+
+func (src *policySource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	src.mu.Lock()
+	src.fetches++
+	src.mu.Unlock()
+
+	if src.release != nil {
+		<-src.release
+	}
+
+	if src.err != nil {
+		return nil, src.err
+	}
+
+	pkg := testPackage("", "community", "flux", "new")
+	return &pkg, nil
+}
+
+// Return an LRUCache holding the "flux" package with a channel head of
+// "old" that is age old, or an empty cache if age is zero.
+func newPolicyTestCache(t *testing.T, age time.Duration) *cache.LRUCache {
+	t.Helper()
+
+	c := cache.NewLRUCache(0).WithLifetime(time.Hour)
+	if age == 0 {
+		return c
+	}
+
+	data, err := json.Marshal(testPackage("", "community", "flux", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutValue("packagemanifests/flux", &cache.Value{Data: data, Timestamp: time.Now().Add(-age)}); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Capture log output until the test finishes.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	return &buf
+}
+
+func TestCachePolicy(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+
+	for _, test := range []struct {
+		name        string
+		policy      CachePolicy
+		age         time.Duration
+		fetchErr    error
+		expected    string
+		expectedErr error
+		fetches     int
+		warning     string
+	}{
+		{"fresh", CacheStrict, time.Minute, nil, "old", nil, 0, ""},
+		{"missing", CacheStaleOnError, 0, nil, "new", nil, 1, ""},
+		{"stale-on-error refreshed", CacheStaleOnError, 2 * time.Hour, nil, "new", nil, 1, ""},
+		{"stale-on-error failed", CacheStaleOnError, 2 * time.Hour, errFetch, "old", nil, 1, "warning: using cached packagemanifests/flux from 2h0m0s ago: fetch failed"},
+		{"stale-on-error missing", CacheStaleOnError, 0, errFetch, "", errFetch, 1, ""},
+		{"strict refreshed", CacheStrict, 2 * time.Hour, nil, "new", nil, 1, ""},
+		{"strict failed", CacheStrict, 2 * time.Hour, errFetch, "", errFetch, 1, ""},
+		{"stale-while-revalidate", CacheStaleWhileRevalidate, 2 * time.Hour, nil, "old", nil, 1, "warning: using cached packagemanifests/flux from 2h0m0s ago while refreshing"},
+		{"stale-while-revalidate failed", CacheStaleWhileRevalidate, 2 * time.Hour, errFetch, "old", nil, 1, "failed to refresh packagemanifests/flux: fetch failed"},
+		{"stale-while-revalidate missing", CacheStaleWhileRevalidate, 0, nil, "new", nil, 1, ""},
+		{"offline", CacheOffline, 2 * time.Hour, nil, "old", nil, 0, ""},
+		{"offline missing", CacheOffline, 0, nil, "", ErrNotCached, 0, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			src := &policySource{err: test.fetchErr}
+			pm := NewPackageManager(src).
+				WithCache(newPolicyTestCache(t, test.age)).
+				WithCachePolicy(test.policy)
+
+			pkg, err := pm.GetPackageManifest(context.Background(), "flux")
+			pm.Wait()
+
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error %v, got %v", test.expectedErr, err)
+			}
+			if err == nil && pkg.Status.Channels[0].CurrentCSV != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, pkg.Status.Channels[0].CurrentCSV)
+			}
+			if src.fetches != test.fetches {
+				t.Errorf("expected %d fetches, got %d", test.fetches, src.fetches)
+			}
+			if test.warning != "" && !strings.Contains(logged.String(), test.warning) {
+				t.Errorf("expected %q in log, got %q", test.warning, logged.String())
+			}
+			if test.warning == "" && strings.Contains(logged.String(), "warning") {
+				t.Errorf("unexpected warning %q", logged.String())
+			}
+		})
+	}
+}
+
+func TestCachePolicyWait(t *testing.T) {
+	captureLog(t)
+
+	src := &policySource{release: make(chan struct{})}
+	c := newPolicyTestCache(t, 2*time.Hour)
+	pm := NewPackageManager(src).WithCache(c).WithCachePolicy(CacheStaleWhileRevalidate)
+
+	// The stale value is returned without waiting for the Source.
+	pkg, err := pm.GetPackageManifest(context.Background(), "flux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Status.Channels[0].CurrentCSV != "old" {
+		t.Errorf("expected old, got %s", pkg.Status.Channels[0].CurrentCSV)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		pm.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before the refresh completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(src.release)
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the refresh completed")
+	}
+
+	// Once Wait returns the refreshed value is in the cache.
+	data, err := c.Get("packagemanifests/flux")
+	if err != nil || data == nil {
+		t.Fatalf("no current value in the cache: %v", err)
+	}
+
+	var cached operators.PackageManifest
+	if err := json.Unmarshal(data, &cached); err != nil {
+		t.Fatal(err)
+	}
+	if cached.Status.Channels[0].CurrentCSV != "new" {
+		t.Errorf("expected new in the cache, got %s", cached.Status.Channels[0].CurrentCSV)
+	}
+}
@@ -0,0 +1,108 @@
+package packagemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type (
+	// CatalogInfo describes the catalog from which a Source reads
+	// packages. These values are used to populate the CatalogSource
+	// fields of synthesized PackageManifests.
+	CatalogInfo struct {
+		Name        string
+		DisplayName string
+		Publisher   string
+		Namespace   string
+	}
+
+	// A catalogChannel is a channel name and the JSON representation of
+	// the ClusterServiceVersion at the head of the channel.
+	catalogChannel struct {
+		name    string
+		csvJSON string
+	}
+)
+
+// Synthesize a PackageManifest from catalog data, in the same way that
+// the packageserver does. Channels for which we are unable to decode the
+// head CSV are omitted from the result.
+func newPackageManifest(catalog CatalogInfo, packageName, defaultChannel string, channels []catalogChannel) (*operators.PackageManifest, error) {
+	manifest := &operators.PackageManifest{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: operators.SchemeGroupVersion.String(),
+			Kind:       "PackageManifest",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      packageName,
+			Namespace: catalog.Namespace,
+			Labels: map[string]string{
+				"catalog":           catalog.Name,
+				"catalog-namespace": catalog.Namespace,
+			},
+		},
+		Status: operators.PackageManifestStatus{
+			CatalogSource:            catalog.Name,
+			CatalogSourceDisplayName: catalog.DisplayName,
+			CatalogSourcePublisher:   catalog.Publisher,
+			CatalogSourceNamespace:   catalog.Namespace,
+			PackageName:              packageName,
+			DefaultChannel:           defaultChannel,
+		},
+	}
+
+	var (
+		providerSet   bool
+		defaultElided bool
+	)
+
+	for _, channel := range channels {
+		var csv operatorsv1alpha1.ClusterServiceVersion
+
+		if err := json.Unmarshal([]byte(channel.csvJSON), &csv); err != nil {
+			log.Printf("%s: failed to decode csv for channel %s: %v", packageName, channel.name, err)
+			defaultElided = defaultElided || channel.name == defaultChannel
+			continue
+		}
+
+		manifest.Status.Channels = append(manifest.Status.Channels, operators.PackageChannel{
+			Name:           channel.name,
+			CurrentCSV:     csv.GetName(),
+			CurrentCSVDesc: operators.CreateCSVDescription(&csv, channel.csvJSON),
+		})
+
+		if channel.name == defaultChannel || !providerSet {
+			manifest.Status.Provider = operators.AppLink{
+				Name: csv.Spec.Provider.Name,
+				URL:  csv.Spec.Provider.URL,
+			}
+			providerSet = true
+		}
+	}
+
+	if len(manifest.Status.Channels) == 0 {
+		return nil, fmt.Errorf("package %s has no valid channels", packageName)
+	}
+
+	if defaultElided || manifest.Status.DefaultChannel == "" {
+		manifest.Status.DefaultChannel = manifest.Status.Channels[0].Name
+	}
+
+	return manifest, nil
+}
+
+// Find a package by name in a list of PackageManifests.
+func findPackageManifest(pkgs []operators.PackageManifest, packageName string) (*operators.PackageManifest, error) {
+	for _, pkg := range pkgs {
+		if pkg.Name == packageName {
+			return &pkg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("package %s not found", packageName)
+}
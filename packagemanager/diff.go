@@ -0,0 +1,180 @@
+package packagemanager
+
+import (
+	"sort"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"golang.org/x/exp/slices"
+)
+
+type (
+	// A CatalogDiff describes the differences between two sets of
+	// PackageManifests. Packages are identified as
+	// namespace/catalogsource/name (or catalogsource/name if the catalog
+	// source has no namespace), since the same package may be provided
+	// by more than one catalog.
+	CatalogDiff struct {
+		AddedPackages   []string
+		RemovedPackages []string
+		ChangedPackages []PackageDiff
+	}
+
+	// A PackageDiff describes the differences between two versions of the
+	// same package.
+	PackageDiff struct {
+		Package           string
+		OldDefaultChannel string
+		NewDefaultChannel string
+		AddedChannels     []operators.PackageChannel
+		RemovedChannels   []operators.PackageChannel
+		ChangedHeads      []ChannelHeadChange
+	}
+
+	// Options for comparing catalogs.
+	DiffOptions struct {
+		// Treat packages from the catalog sources named by the keys
+		// in the old catalog as if they came from the catalog sources
+		// named by the values. Names may be qualified with a
+		// namespace ("namespace/name"). This allows comparing
+		// catalogs whose names differ, such as a File-Based Catalog
+		// (named after its directory) and a cluster.
+		CatalogMap map[string]string
+	}
+
+	// A ChannelHeadChange records a change to the CSV at the head of a
+	// channel.
+	ChannelHeadChange struct {
+		Channel string
+		OldCSV  string
+		NewCSV  string
+	}
+)
+
+// Return the name of the catalog source for a package, qualified with
+// its namespace if it has one.
+func catalogName(pkg *operators.PackageManifest) string {
+	if pkg.Status.CatalogSourceNamespace == "" {
+		return pkg.Status.CatalogSource
+	}
+
+	return pkg.Status.CatalogSourceNamespace + "/" + pkg.Status.CatalogSource
+}
+
+// Return the key used to match packages when comparing catalogs, after
+// applying catalogMap.
+func packageKey(pkg *operators.PackageManifest, catalogMap map[string]string) string {
+	catalog := catalogName(pkg)
+
+	if mapped, ok := catalogMap[catalog]; ok {
+		catalog = mapped
+	} else if mapped, ok := catalogMap[pkg.Status.CatalogSource]; ok {
+		// An unqualified name keeps the namespace of the package.
+		catalog = mapped
+		if !strings.Contains(mapped, "/") && pkg.Status.CatalogSourceNamespace != "" {
+			catalog = pkg.Status.CatalogSourceNamespace + "/" + mapped
+		}
+	}
+
+	return catalog + "/" + pkg.Name
+}
+
+// Compare two lists of PackageManifests.
+func DiffPackageManifests(oldPkgs, newPkgs []operators.PackageManifest) *CatalogDiff {
+	return DiffOptions{}.Diff(oldPkgs, newPkgs)
+}
+
+// Compare two lists of PackageManifests.
+func (options DiffOptions) Diff(oldPkgs, newPkgs []operators.PackageManifest) *CatalogDiff {
+	diff := &CatalogDiff{}
+
+	oldByKey := make(map[string]*operators.PackageManifest)
+	for i := range oldPkgs {
+		oldByKey[packageKey(&oldPkgs[i], options.CatalogMap)] = &oldPkgs[i]
+	}
+
+	newByKey := make(map[string]*operators.PackageManifest)
+	for i := range newPkgs {
+		newByKey[packageKey(&newPkgs[i], nil)] = &newPkgs[i]
+	}
+
+	for key, newPkg := range newByKey {
+		oldPkg, ok := oldByKey[key]
+		if !ok {
+			diff.AddedPackages = append(diff.AddedPackages, key)
+			continue
+		}
+
+		if pkgDiff := diffPackage(key, oldPkg, newPkg); pkgDiff != nil {
+			diff.ChangedPackages = append(diff.ChangedPackages, *pkgDiff)
+		}
+	}
+
+	for key := range oldByKey {
+		if _, ok := newByKey[key]; !ok {
+			diff.RemovedPackages = append(diff.RemovedPackages, key)
+		}
+	}
+
+	sort.Strings(diff.AddedPackages)
+	sort.Strings(diff.RemovedPackages)
+	sort.Slice(diff.ChangedPackages, func(i, j int) bool {
+		return diff.ChangedPackages[i].Package < diff.ChangedPackages[j].Package
+	})
+
+	return diff
+}
+
+// Compare two versions of a package. Returns nil if there are no
+// differences.
+func diffPackage(key string, oldPkg, newPkg *operators.PackageManifest) *PackageDiff {
+	pkgDiff := PackageDiff{
+		Package:           key,
+		OldDefaultChannel: oldPkg.Status.DefaultChannel,
+		NewDefaultChannel: newPkg.Status.DefaultChannel,
+	}
+
+	changed := oldPkg.Status.DefaultChannel != newPkg.Status.DefaultChannel
+
+	for _, newChannel := range newPkg.Status.Channels {
+		idx := slices.IndexFunc(oldPkg.Status.Channels, func(c operators.PackageChannel) bool {
+			return c.Name == newChannel.Name
+		})
+		if idx == -1 {
+			pkgDiff.AddedChannels = append(pkgDiff.AddedChannels, newChannel)
+			changed = true
+			continue
+		}
+
+		if oldCSV := oldPkg.Status.Channels[idx].CurrentCSV; oldCSV != newChannel.CurrentCSV {
+			pkgDiff.ChangedHeads = append(pkgDiff.ChangedHeads, ChannelHeadChange{
+				Channel: newChannel.Name,
+				OldCSV:  oldCSV,
+				NewCSV:  newChannel.CurrentCSV,
+			})
+			changed = true
+		}
+	}
+
+	for _, oldChannel := range oldPkg.Status.Channels {
+		if slices.IndexFunc(newPkg.Status.Channels, func(c operators.PackageChannel) bool {
+			return c.Name == oldChannel.Name
+		}) == -1 {
+			pkgDiff.RemovedChannels = append(pkgDiff.RemovedChannels, oldChannel)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return &pkgDiff
+}
+
+// Return true if there are no differences.
+func (diff *CatalogDiff) Empty() bool {
+	return len(diff.AddedPackages) == 0 &&
+		len(diff.RemovedPackages) == 0 &&
+		len(diff.ChangedPackages) == 0
+}
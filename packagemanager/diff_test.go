@@ -0,0 +1,68 @@
+package packagemanager
+
+import (
+	"reflect"
+	"testing"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+func TestDiffPackageManifests(t *testing.T) {
+	oldPkgs := []operators.PackageManifest{
+		testPackage("olm", "community", "flux", "flux.v1"),
+		testPackage("olm", "community", "argo", "argo.v1"),
+	}
+	newPkgs := []operators.PackageManifest{
+		testPackage("olm", "community", "flux", "flux.v2"),
+		testPackage("team", "community", "argo", "argo.v1"),
+	}
+
+	diff := DiffPackageManifests(oldPkgs, newPkgs)
+
+	// A catalog source of the same name in another namespace is a
+	// different catalog.
+	if !reflect.DeepEqual(diff.AddedPackages, []string{"team/community/argo"}) {
+		t.Errorf("unexpected added packages %v", diff.AddedPackages)
+	}
+	if !reflect.DeepEqual(diff.RemovedPackages, []string{"olm/community/argo"}) {
+		t.Errorf("unexpected removed packages %v", diff.RemovedPackages)
+	}
+	if len(diff.ChangedPackages) != 1 || diff.ChangedPackages[0].Package != "olm/community/flux" {
+		t.Errorf("unexpected changed packages %+v", diff.ChangedPackages)
+	}
+}
+
+func TestDiffPackageManifestsCatalogMap(t *testing.T) {
+	// A File-Based Catalog snapshot, named after its directory.
+	oldPkgs := []operators.PackageManifest{
+		testPackage("", "catalog", "flux", "flux.v1"),
+		testPackage("", "catalog", "argo", "argo.v1"),
+	}
+	newPkgs := []operators.PackageManifest{
+		testPackage("olm", "community", "flux", "flux.v2"),
+		testPackage("olm", "community", "argo", "argo.v1"),
+	}
+
+	if diff := DiffPackageManifests(oldPkgs, newPkgs); len(diff.AddedPackages) != 2 || len(diff.RemovedPackages) != 2 {
+		t.Errorf("expected every package to be added and removed without a mapping, got %+v", diff)
+	}
+
+	for _, mapped := range []string{"olm/community", "community"} {
+		catalogMap := map[string]string{"catalog": mapped}
+		if mapped == "community" {
+			// An unqualified name keeps the namespace of the old
+			// package, which must then match.
+			for i := range oldPkgs {
+				oldPkgs[i].Status.CatalogSourceNamespace = "olm"
+			}
+		}
+
+		diff := DiffOptions{CatalogMap: catalogMap}.Diff(oldPkgs, newPkgs)
+		if len(diff.AddedPackages) != 0 || len(diff.RemovedPackages) != 0 {
+			t.Errorf("%s: unexpected added %v and removed %v packages", mapped, diff.AddedPackages, diff.RemovedPackages)
+		}
+		if len(diff.ChangedPackages) != 1 || diff.ChangedPackages[0].Package != "olm/community/flux" {
+			t.Errorf("%s: unexpected changed packages %+v", mapped, diff.ChangedPackages)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/blang/semver/v4"
+	"github.com/operator-framework/api/pkg/lib/version"
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type (
+	// An FBCSource synthesizes PackageManifests from an operator-registry
+	// File-Based Catalog [1]: a directory of olm.package, olm.channel and
+	// olm.bundle blobs in JSON or YAML format.
+	//
+	// [1]: https://olm.operatorframework.io/docs/reference/file-based-catalogs/
+	FBCSource struct {
+		path     string
+		catalog  CatalogInfo
+		packages []operators.PackageManifest
+		loaded   bool
+
+		fbcPackages []fbcPackage
+		fbcChannels map[string][]fbcChannel
+		fbcBundles  map[string]map[string]fbcBundle
+	}
+
+	fbcMeta struct {
+		Schema  string `json:"schema"`
+		Package string `json:"package"`
+		Name    string `json:"name"`
+	}
+
+	fbcPackage struct {
+		Name           string `json:"name"`
+		DefaultChannel string `json:"defaultChannel"`
+	}
+
+	fbcChannel struct {
+		Package string            `json:"package"`
+		Name    string            `json:"name"`
+		Entries []fbcChannelEntry `json:"entries"`
+	}
+
+	fbcChannelEntry struct {
+		Name      string   `json:"name"`
+		Replaces  string   `json:"replaces,omitempty"`
+		Skips     []string `json:"skips,omitempty"`
+		SkipRange string   `json:"skipRange,omitempty"`
+	}
+
+	fbcBundle struct {
+		Package       string                           `json:"package"`
+		Name          string                           `json:"name"`
+		Image         string                           `json:"image"`
+		Properties    []fbcProperty                    `json:"properties"`
+		RelatedImages []operatorsv1alpha1.RelatedImage `json:"relatedImages,omitempty"`
+	}
+
+	fbcProperty struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+
+	// The value of an olm.csv.metadata property.
+	fbcCSVMetadata struct {
+		Annotations               map[string]string                           `json:"annotations,omitempty"`
+		APIServiceDefinitions     operatorsv1alpha1.APIServiceDefinitions     `json:"apiServiceDefinitions,omitempty"`
+		CustomResourceDefinitions operatorsv1alpha1.CustomResourceDefinitions `json:"crdDescriptions,omitempty"`
+		Description               string                                      `json:"description,omitempty"`
+		DisplayName               string                                      `json:"displayName,omitempty"`
+		InstallModes              []operatorsv1alpha1.InstallMode             `json:"installModes,omitempty"`
+		Keywords                  []string                                    `json:"keywords,omitempty"`
+		Labels                    map[string]string                           `json:"labels,omitempty"`
+		Links                     []operatorsv1alpha1.AppLink                 `json:"links,omitempty"`
+		Maintainers               []operatorsv1alpha1.Maintainer              `json:"maintainers,omitempty"`
+		Maturity                  string                                      `json:"maturity,omitempty"`
+		MinKubeVersion            string                                      `json:"minKubeVersion,omitempty"`
+		NativeAPIs                []metav1.GroupVersionKind                   `json:"nativeAPIs,omitempty"`
+		Provider                  operatorsv1alpha1.AppLink                   `json:"provider,omitempty"`
+	}
+)
+
+const (
+	fbcSchemaPackage = "olm.package"
+	fbcSchemaChannel = "olm.channel"
+	fbcSchemaBundle  = "olm.bundle"
+
+	fbcPropertyPackage      = "olm.package"
+	fbcPropertyBundleObject = "olm.bundle.object"
+	fbcPropertyCSVMetadata  = "olm.csv.metadata"
+)
+
+// Create a new FBCSource that reads a File-Based Catalog from path. By
+// default the catalog is named after the last component of path.
+func NewFBCSource(path string) *FBCSource {
+	name := filepath.Base(filepath.Clean(path))
+	return &FBCSource{
+		path: path,
+		catalog: CatalogInfo{
+			Name:        name,
+			DisplayName: name,
+		},
+	}
+}
+
+// Set the catalog information reported in synthesized PackageManifests.
+func (src *FBCSource) WithCatalogInfo(catalog CatalogInfo) *FBCSource {
+	src.catalog = catalog
+	return src
+}
+
+// Read the catalog and build PackageManifests. We only do this once (until
+// Reload is called); the results are kept in memory.
+func (src *FBCSource) load() error {
+	if src.loaded {
+		return nil
+	}
+
+	// Start afresh, in case an earlier attempt failed part way.
+	src.fbcPackages = nil
+	src.fbcChannels = make(map[string][]fbcChannel)
+	src.fbcBundles = make(map[string]map[string]fbcBundle)
+	src.packages = nil
+
+	if err := walkDocuments(src.path, src.loadBlob); err != nil {
+		return err
+	}
+
+	for _, pkg := range src.fbcPackages {
+		var channels []catalogChannel
+
+		for _, channel := range src.fbcChannels[pkg.Name] {
+			head, err := channelHead(channel.Entries)
+			if err != nil {
+				log.Printf("%s: eliding channel %s: %v", pkg.Name, channel.Name, err)
+				continue
+			}
+
+			bundle, ok := src.fbcBundles[pkg.Name][head]
+			if !ok {
+				log.Printf("%s: eliding channel %s: missing bundle %s", pkg.Name, channel.Name, head)
+				continue
+			}
+
+			csvJSON, err := bundle.csvJSON()
+			if err != nil {
+				log.Printf("%s: eliding channel %s: %v", pkg.Name, channel.Name, err)
+				continue
+			}
+
+			channels = append(channels, catalogChannel{
+				name:    channel.Name,
+				csvJSON: csvJSON,
+			})
+		}
+
+		manifest, err := newPackageManifest(src.catalog, pkg.Name, pkg.DefaultChannel, channels)
+		if err != nil {
+			log.Printf("skipping package: %v", err)
+			continue
+		}
+
+		src.packages = append(src.packages, *manifest)
+	}
+
+	src.loaded = true
+	return nil
+}
+
+// Read the catalog again the next time we need it.
+func (src *FBCSource) Reload() {
+	src.loaded = false
+}
+
+// Process a single catalog blob. Blobs with unknown schemas are ignored.
+func (src *FBCSource) loadBlob(doc json.RawMessage) error {
+	var meta fbcMeta
+
+	if err := json.Unmarshal(doc, &meta); err != nil {
+		return err
+	}
+
+	switch meta.Schema {
+	case fbcSchemaPackage:
+		var pkg fbcPackage
+		if err := json.Unmarshal(doc, &pkg); err != nil {
+			return err
+		}
+		src.fbcPackages = append(src.fbcPackages, pkg)
+	case fbcSchemaChannel:
+		var channel fbcChannel
+		if err := json.Unmarshal(doc, &channel); err != nil {
+			return err
+		}
+		src.fbcChannels[channel.Package] = append(src.fbcChannels[channel.Package], channel)
+	case fbcSchemaBundle:
+		var bundle fbcBundle
+		if err := json.Unmarshal(doc, &bundle); err != nil {
+			return err
+		}
+		if src.fbcBundles[bundle.Package] == nil {
+			src.fbcBundles[bundle.Package] = make(map[string]fbcBundle)
+		}
+		src.fbcBundles[bundle.Package][bundle.Name] = bundle
+	}
+
+	return nil
+}
+
+// Return the JSON representation of the bundle's ClusterServiceVersion.
+// If the bundle embeds the CSV as an olm.bundle.object property we return
+// that directly; otherwise we synthesize a CSV from the olm.csv.metadata
+// property (or from the bundle name and version alone if that is not
+// available either).
+func (bundle *fbcBundle) csvJSON() (string, error) {
+	var metadata *fbcCSVMetadata
+
+	for _, property := range bundle.Properties {
+		switch property.Type {
+		case fbcPropertyBundleObject:
+			var object struct {
+				Data string `json:"data"`
+			}
+			if err := json.Unmarshal(property.Value, &object); err != nil {
+				return "", err
+			}
+			data, err := base64.StdEncoding.DecodeString(object.Data)
+			if err != nil {
+				return "", err
+			}
+
+			var meta metav1.TypeMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return "", err
+			}
+			if meta.Kind == operatorsv1alpha1.ClusterServiceVersionKind {
+				return string(data), nil
+			}
+		case fbcPropertyCSVMetadata:
+			metadata = &fbcCSVMetadata{}
+			if err := json.Unmarshal(property.Value, metadata); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	csv := operatorsv1alpha1.ClusterServiceVersion{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: operatorsv1alpha1.ClusterServiceVersionAPIVersion,
+			Kind:       operatorsv1alpha1.ClusterServiceVersionKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: bundle.Name,
+		},
+		Spec: operatorsv1alpha1.ClusterServiceVersionSpec{
+			RelatedImages: bundle.RelatedImages,
+		},
+	}
+
+	if bundleVersion := bundle.version(); bundleVersion != "" {
+		v, err := semver.Parse(bundleVersion)
+		if err != nil {
+			return "", err
+		}
+		csv.Spec.Version = version.OperatorVersion{Version: v}
+	}
+
+	if metadata != nil {
+		csv.ObjectMeta.Annotations = metadata.Annotations
+		csv.ObjectMeta.Labels = metadata.Labels
+		csv.Spec.APIServiceDefinitions = metadata.APIServiceDefinitions
+		csv.Spec.CustomResourceDefinitions = metadata.CustomResourceDefinitions
+		csv.Spec.Description = metadata.Description
+		csv.Spec.DisplayName = metadata.DisplayName
+		csv.Spec.InstallModes = metadata.InstallModes
+		csv.Spec.Keywords = metadata.Keywords
+		csv.Spec.Links = metadata.Links
+		csv.Spec.Maintainers = metadata.Maintainers
+		csv.Spec.Maturity = metadata.Maturity
+		csv.Spec.MinKubeVersion = metadata.MinKubeVersion
+		csv.Spec.NativeAPIs = metadata.NativeAPIs
+		csv.Spec.Provider = metadata.Provider
+	}
+
+	data, err := json.Marshal(csv)
+	return string(data), err
+}
+
+// Return the bundle version from the olm.package property, or an empty
+// string if the bundle does not have one.
+func (bundle *fbcBundle) version() string {
+	var pkgProperty struct {
+		Version string `json:"version"`
+	}
+
+	for _, property := range bundle.Properties {
+		if property.Type == fbcPropertyPackage {
+			if err := json.Unmarshal(property.Value, &pkgProperty); err == nil {
+				return pkgProperty.Version
+			}
+		}
+	}
+
+	return ""
+}
+
+// Find the head of a channel: the single entry that is neither replaced
+// nor skipped by any other entry.
+func channelHead(entries []fbcChannelEntry) (string, error) {
+	incoming := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Replaces != "" {
+			incoming[entry.Replaces] = true
+		}
+		for _, skip := range entry.Skips {
+			incoming[skip] = true
+		}
+	}
+
+	var heads []string
+	for _, entry := range entries {
+		if !incoming[entry.Name] {
+			heads = append(heads, entry.Name)
+		}
+	}
+
+	switch len(heads) {
+	case 0:
+		return "", errors.New("no channel head found")
+	case 1:
+		return heads[0], nil
+	default:
+		return "", fmt.Errorf("multiple channel heads found: %v", heads)
+	}
+}
+
+func (src *FBCSource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	return findPackageManifest(src.packages, packageName)
+}
+
+func (src *FBCSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	return src.packages, nil
+}
+
+func (src *FBCSource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	var entries []ChannelEntry
+
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	for _, channel := range src.fbcChannels[packageName] {
+		if channel.Name != channelName {
+			continue
+		}
+
+		for _, entry := range channel.Entries {
+			bundle := src.fbcBundles[packageName][entry.Name]
+			entries = append(entries, ChannelEntry{
+				Name:      entry.Name,
+				Version:   bundle.version(),
+				Replaces:  entry.Replaces,
+				Skips:     entry.Skips,
+				SkipRange: entry.SkipRange,
+			})
+		}
+
+		return entries, nil
+	}
+
+	return nil, fmt.Errorf("channel %s not found", channelName)
+}
@@ -0,0 +1,133 @@
+package packagemanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Copy the files in the source directory to the target directory.
+func copyDir(t *testing.T, source, target string) {
+	t.Helper()
+
+	err := filepath.WalkDir(source, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(target, rel), 0700)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(target, rel), data, 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFBCSource(t *testing.T) {
+	src := NewFBCSource("testdata/fbc")
+	ctx := context.Background()
+
+	pkgs, err := src.ListPackageManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd,flux" {
+		t.Errorf("expected etcd,flux, got %s", names)
+	}
+
+	// The etcd CSV is embedded in the bundle; the flux one is built from
+	// its metadata.
+	for _, test := range []struct {
+		name, head, provider, keyword string
+	}{
+		{"etcd", "etcdoperator.v0.9.4", "CNCF", "database"},
+		{"flux", "flux.v0.15.3", "Flux Community", "gitops"},
+	} {
+		pkg, err := src.GetPackageManifest(ctx, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pkg.Status.CatalogSource != "fbc" || pkg.Status.Provider.Name != test.provider {
+			t.Errorf("%s: unexpected package %+v", test.name, pkg.Status)
+		}
+		if len(pkg.Status.Channels) != 1 || pkg.Status.Channels[0].CurrentCSV != test.head {
+			t.Errorf("%s: unexpected channels %+v", test.name, pkg.Status.Channels)
+			continue
+		}
+		if keywords := pkg.Status.Channels[0].CurrentCSVDesc.Keywords; len(keywords) == 0 || keywords[len(keywords)-1] != test.keyword {
+			t.Errorf("%s: unexpected keywords %v", test.name, keywords)
+		}
+	}
+
+	entries, err := src.GetChannelEntries(ctx, "etcd", "singlenamespace-alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ChannelEntry{
+		{Name: "etcdoperator.v0.9.4", Version: "0.9.4", Replaces: "etcdoperator.v0.9.2"},
+		{Name: "etcdoperator.v0.9.2", Version: "0.9.2"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+}
+
+func TestFBCSourceReload(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, "testdata/fbc", dir)
+
+	src := NewFBCSource(dir)
+	ctx := context.Background()
+
+	if _, err := src.ListPackageManifests(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading an unchanged catalog must not duplicate anything.
+	src.Reload()
+	pkgs, err := src.ListPackageManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd,flux" {
+		t.Errorf("after reload: expected etcd,flux, got %s", names)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "flux")); err != nil {
+		t.Fatal(err)
+	}
+
+	// We keep what we read until we are asked to reload.
+	pkgs, err = src.ListPackageManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd,flux" {
+		t.Errorf("before reload: expected etcd,flux, got %s", names)
+	}
+
+	src.Reload()
+	pkgs, err = src.ListPackageManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd" {
+		t.Errorf("after removing flux: expected etcd, got %s", names)
+	}
+}
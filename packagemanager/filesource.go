@@ -0,0 +1,202 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"golang.org/x/exp/slices"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+type (
+	// A FileSource reads PackageManifests from a local YAML or JSON file,
+	// or from a directory of such files. Files may contain a
+	// PackageManifestList (such as the output of "kubectl get
+	// packagemanifests -o yaml"), a generic List, or individual
+	// PackageManifests.
+	FileSource struct {
+		path     string
+		packages []operators.PackageManifest
+		raw      map[string]json.RawMessage
+		loaded   bool
+	}
+)
+
+var fileSourceExtensions = []string{".yaml", ".yml", ".json"}
+
+// Create a new FileSource that reads packages from path, which may be
+// either a file or a directory.
+func NewFileSource(path string) *FileSource {
+	return &FileSource{
+		path: path,
+	}
+}
+
+// Read all packages from the configured path. We only do this once (until
+// Reload is called); the results are kept in memory.
+func (src *FileSource) load() error {
+	if src.loaded {
+		return nil
+	}
+
+	src.packages = nil
+	src.raw = make(map[string]json.RawMessage)
+	if err := walkDocuments(src.path, src.loadDocument); err != nil {
+		return err
+	}
+
+	src.loaded = true
+	return nil
+}
+
+// Read the files again the next time we need them.
+func (src *FileSource) Reload() {
+	src.loaded = false
+}
+
+// Call fn for every YAML or JSON document in path. If path is a directory,
+// process all files with a YAML or JSON extension found beneath it.
+func walkDocuments(path string, fn func(doc json.RawMessage) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return readDocuments(path, fn)
+	}
+
+	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !slices.Contains(fileSourceExtensions, strings.ToLower(filepath.Ext(path))) {
+			return nil
+		}
+
+		return readDocuments(path, fn)
+	})
+}
+
+// Call fn for every document in a single file. Empty documents are
+// skipped.
+func readDocuments(path string, fn func(doc json.RawMessage) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(fd, 4096)
+	for {
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if len(doc) == 0 || string(doc) == "null" {
+			continue
+		}
+
+		if err := fn(doc); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Add the package(s) in a single document to our list of packages.
+func (src *FileSource) loadDocument(doc json.RawMessage) error {
+	var meta struct {
+		Kind string `json:"kind"`
+	}
+
+	if err := json.Unmarshal(doc, &meta); err != nil {
+		return err
+	}
+
+	switch meta.Kind {
+	case "PackageManifestList", "List":
+		var pkgs struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(doc, &pkgs); err != nil {
+			return err
+		}
+		for _, item := range pkgs.Items {
+			if err := src.loadPackage(item); err != nil {
+				return err
+			}
+		}
+	case "PackageManifest":
+		return src.loadPackage(doc)
+	default:
+		return fmt.Errorf("unsupported kind %q", meta.Kind)
+	}
+
+	return nil
+}
+
+// Add a single PackageManifest to our list of packages. We hold on to the
+// raw JSON so that we can extract fields that are not part of the
+// PackageManifest type.
+func (src *FileSource) loadPackage(doc json.RawMessage) error {
+	var pkg operators.PackageManifest
+
+	if err := json.Unmarshal(doc, &pkg); err != nil {
+		return err
+	}
+
+	if pkg.Kind != "" && pkg.Kind != "PackageManifest" {
+		return nil
+	}
+
+	src.packages = append(src.packages, pkg)
+	if _, ok := src.raw[pkg.Name]; !ok {
+		src.raw[pkg.Name] = doc
+	}
+
+	return nil
+}
+
+func (src *FileSource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	return findPackageManifest(src.packages, packageName)
+}
+
+func (src *FileSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	return src.packages, nil
+}
+
+func (src *FileSource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+
+	data, ok := src.raw[packageName]
+	if !ok {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
+
+	return channelEntriesFromManifest(data, channelName)
+}
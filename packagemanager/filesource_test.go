@@ -0,0 +1,58 @@
+package packagemanager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFileSource(t *testing.T) {
+	for _, path := range []string{"testdata/packages.yaml", "testdata/manifests"} {
+		t.Run(path, func(t *testing.T) {
+			src := NewFileSource(path)
+			ctx := context.Background()
+
+			pkgs, err := src.ListPackageManifests(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := packageNames(pkgs); names != "etcd,flux" {
+				t.Errorf("expected etcd,flux, got %s", names)
+			}
+
+			pkg, err := src.GetPackageManifest(ctx, "flux")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkg.Status.Provider.Name != "Flux Community" || pkg.Status.CatalogSource != "community-operators" {
+				t.Errorf("unexpected package %+v", pkg.Status)
+			}
+
+			if _, err := src.GetPackageManifest(ctx, "missing"); err == nil {
+				t.Error("expected an error for a missing package")
+			}
+		})
+	}
+}
+
+func TestFileSourceChannelEntries(t *testing.T) {
+	src := NewFileSource("testdata/packages.yaml")
+
+	entries, err := src.GetChannelEntries(context.Background(), "etcd", "singlenamespace-alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ChannelEntry{
+		{Name: "etcdoperator.v0.9.4", Version: "0.9.4"},
+		{Name: "etcdoperator.v0.9.2", Version: "0.9.2"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	// Older packageservers don't list the entries.
+	if _, err := src.GetChannelEntries(context.Background(), "flux", "alpha"); err == nil {
+		t.Error("expected an error for a channel without entries")
+	}
+}
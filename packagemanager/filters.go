@@ -9,6 +9,14 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+type (
+	// A filter that matches packages matched by all of its filters.
+	allFilter []PackageManifestFilter
+
+	// A filter that matches packages matched by any of its filters.
+	anyFilter []PackageManifestFilter
+)
+
 // Return a file that matches package names against a list of
 // glob patterns. Comparisons are case insensitive.
 func MatchPackageGlobs(patterns ...string) PackageManifestFilter {
@@ -16,15 +24,24 @@ func MatchPackageGlobs(patterns ...string) PackageManifestFilter {
 		patterns[i] = strings.ToLower(patterns[i])
 	}
 
-	return func(pkg *operators.PackageManifest) bool {
+	match := func(name string) bool {
 		for _, pattern := range patterns {
-			if matches, _ := filepath.Match(pattern, strings.ToLower(pkg.Name)); matches {
+			if matches, _ := filepath.Match(pattern, name); matches {
 				return true
 			}
 		}
 
 		return false
 	}
+
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			return match(strings.ToLower(pkg.Name))
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.scan(idx.Names, match)
+		},
+	}
 }
 
 // Return a filter that matches package names against a list of words.
@@ -34,24 +51,41 @@ func MatchPackageSubstrings(patterns ...string) PackageManifestFilter {
 		patterns[i] = strings.ToLower(patterns[i])
 	}
 
-	return func(pkg *operators.PackageManifest) bool {
+	match := func(name string) bool {
 		for _, pattern := range patterns {
-			if strings.Contains(strings.ToLower(pkg.Name), pattern) {
+			if strings.Contains(name, pattern) {
 				return true
 			}
 		}
 
 		return false
 	}
+
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			return match(strings.ToLower(pkg.Name))
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.scan(idx.Names, match)
+		},
+	}
 }
 
 // Returns a filter that matches a specific package name. Comparisons are
 // case insensitive.
 func MatchPackageName(pattern string) PackageManifestFilter {
-	pattern = strings.ToLower(pattern)
-	return func(pkg *operators.PackageManifest) bool {
-		matches, _ := filepath.Match(pattern, strings.ToLower(pkg.Name))
-		return matches
+	return MatchPackageGlobs(pattern)
+}
+
+// Return a filter that matches a package name exactly.
+func matchExactPackageName(name string) PackageManifestFilter {
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			return pkg.Name == name
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.lookup(idx.Names, strings.ToLower(name))
+		},
 	}
 }
 
@@ -59,9 +93,18 @@ func MatchPackageName(pattern string) PackageManifestFilter {
 // Comparisons are case insensitive.
 func MatchCatalogSource(needle string) PackageManifestFilter {
 	needle = strings.ToLower(needle)
-	return func(pkg *operators.PackageManifest) bool {
-		return strings.Contains(strings.ToLower(pkg.Status.CatalogSource), needle) ||
-			strings.Contains(strings.ToLower(pkg.Status.CatalogSourceDisplayName), needle)
+	match := func(value string) bool {
+		return strings.Contains(value, needle)
+	}
+
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			return match(strings.ToLower(pkg.Status.CatalogSource)) ||
+				match(strings.ToLower(pkg.Status.CatalogSourceDisplayName))
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.scan(idx.CatalogSources, match)
+		},
 	}
 }
 
@@ -69,7 +112,7 @@ func MatchCatalogSource(needle string) PackageManifestFilter {
 // Comparisons are case insensitive.
 func MatchDescription(needle string) PackageManifestFilter {
 	needle = strings.ToLower(needle)
-	return func(pkg *operators.PackageManifest) bool {
+	return FilterFunc(func(pkg *operators.PackageManifest) bool {
 		for _, channel := range pkg.Status.Channels {
 			if strings.Contains(strings.ToLower(channel.CurrentCSVDesc.LongDescription), needle) {
 				return true
@@ -77,14 +120,14 @@ func MatchDescription(needle string) PackageManifestFilter {
 		}
 
 		return false
-	}
+	})
 }
 
 // Return a filter that matches the package InstallMode against a substring.
 // Comparisons are case insensitive.
 func MatchInstallMode(installmode string) PackageManifestFilter {
 	installmode = strings.ToLower(installmode)
-	return func(pkg *operators.PackageManifest) bool {
+	return FilterFunc(func(pkg *operators.PackageManifest) bool {
 		for _, channel := range pkg.Status.Channels {
 			for _, mode := range channel.CurrentCSVDesc.InstallModes {
 				if strings.ToLower(string(mode.Type)) == installmode && mode.Supported {
@@ -94,7 +137,7 @@ func MatchInstallMode(installmode string) PackageManifestFilter {
 		}
 
 		return false
-	}
+	})
 }
 
 // Return a filter that matches packages if they contain any of the given
@@ -104,23 +147,28 @@ func MatchKeywords(keywords []string) PackageManifestFilter {
 		keywords[i] = strings.ToLower(keywords[i])
 	}
 
-	return func(pkg *operators.PackageManifest) bool {
-		for _, channel := range pkg.Status.Channels {
-			// generate lowercase version of keywords
-			// for case-insensitive comparison
-			var hasKeywords []string
-			for _, keyword := range channel.CurrentCSVDesc.Keywords {
-				hasKeywords = append(hasKeywords, strings.ToLower(keyword))
-			}
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			for _, channel := range pkg.Status.Channels {
+				// generate lowercase version of keywords
+				// for case-insensitive comparison
+				var hasKeywords []string
+				for _, keyword := range channel.CurrentCSVDesc.Keywords {
+					hasKeywords = append(hasKeywords, strings.ToLower(keyword))
+				}
 
-			for _, keyword := range keywords {
-				if slices.Contains(hasKeywords, keyword) {
-					return true
+				for _, keyword := range keywords {
+					if slices.Contains(hasKeywords, keyword) {
+						return true
+					}
 				}
 			}
-		}
 
-		return false
+			return false
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.lookup(idx.Keywords, keywords...)
+		},
 	}
 }
 
@@ -128,7 +176,7 @@ func MatchKeywords(keywords []string) PackageManifestFilter {
 // certified attribute.
 func MatchCertified(certified bool) PackageManifestFilter {
 	certifiedString := strconv.FormatBool(certified)
-	return func(pkg *operators.PackageManifest) bool {
+	return FilterFunc(func(pkg *operators.PackageManifest) bool {
 		for _, channel := range pkg.Status.Channels {
 			if channel.CurrentCSVDesc.Annotations["certified"] == certifiedString {
 				return true
@@ -136,5 +184,62 @@ func MatchCertified(certified bool) PackageManifestFilter {
 		}
 
 		return false
+	})
+}
+
+// Return a filter that matches the package provider against a substring.
+// Comparisons are case insensitive.
+func MatchProvider(needle string) PackageManifestFilter {
+	needle = strings.ToLower(needle)
+	match := func(value string) bool {
+		return strings.Contains(value, needle)
+	}
+
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			return match(strings.ToLower(pkg.Status.Provider.Name))
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.scan(idx.Providers, match)
+		},
+	}
+}
+
+// Return a filter that matches packages matched by all of the given
+// filters.
+func MatchAll(filters ...PackageManifestFilter) PackageManifestFilter {
+	return allFilter(filters)
+}
+
+func (filters allFilter) Match(pkg *operators.PackageManifest) bool {
+	for _, filter := range filters {
+		if !filter.Match(pkg) {
+			return false
+		}
 	}
+
+	return true
+}
+
+// Return a filter that matches packages matched by any of the given
+// filters.
+func MatchAny(filters ...PackageManifestFilter) PackageManifestFilter {
+	return anyFilter(filters)
+}
+
+func (filters anyFilter) Match(pkg *operators.PackageManifest) bool {
+	for _, filter := range filters {
+		if filter.Match(pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return a filter that matches packages not matched by the given filter.
+func MatchNot(filter PackageManifestFilter) PackageManifestFilter {
+	return FilterFunc(func(pkg *operators.PackageManifest) bool {
+		return !filter.Match(pkg)
+	})
 }
@@ -0,0 +1,272 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/blang/semver/v4"
+)
+
+type (
+	// A ChannelEntry is a single bundle in a channel, along with the
+	// information OLM uses to build the upgrade graph. Replaces, Skips and
+	// SkipRange are only available from sources that provide them.
+	ChannelEntry struct {
+		Name      string   `json:"name"`
+		Version   string   `json:"version,omitempty"`
+		Replaces  string   `json:"replaces,omitempty"`
+		Skips     []string `json:"skips,omitempty"`
+		SkipRange string   `json:"skipRange,omitempty"`
+	}
+
+	// A GraphSource is a Source that is able to provide the entries in a
+	// channel.
+	GraphSource interface {
+		GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error)
+	}
+
+	// An UpgradeGraph describes the possible upgrades between the
+	// bundles in a single channel of a package.
+	UpgradeGraph struct {
+		Package string
+		Channel string
+		Head    string
+		Nodes   []GraphNode
+		Edges   []GraphEdge
+
+		// True if the source didn't say how the bundles in the channel
+		// are connected (the packageserver only lists them), in which
+		// case the graph has no edges.
+		EdgesUnknown bool
+	}
+
+	GraphNode struct {
+		Name    string
+		Version string
+	}
+
+	// A GraphEdge indicates that OLM can upgrade from one bundle to
+	// another.
+	GraphEdge struct {
+		From string
+		To   string
+		Type EdgeType
+	}
+
+	EdgeType string
+
+	// Newer versions of the packageserver include the entries in each
+	// channel (ordered from newest to oldest) in PackageManifests, but
+	// the version of the OLM API types that we use does not know about
+	// them.
+	packageManifestEntries struct {
+		Status struct {
+			Channels []struct {
+				Name    string         `json:"name"`
+				Entries []ChannelEntry `json:"entries"`
+			} `json:"channels"`
+		} `json:"status"`
+	}
+)
+
+// Returned by PathToHead when the graph has no edges because the source
+// doesn't provide them.
+var ErrEdgesUnknown = errors.New("the package source does not say which bundles replace or skip others")
+
+const (
+	EdgeReplaces  EdgeType = "replaces"
+	EdgeSkips     EdgeType = "skips"
+	EdgeSkipRange EdgeType = "skipRange"
+)
+
+// Build an UpgradeGraph from the entries in a channel.
+func NewUpgradeGraph(packageName, channelName, head string, entries []ChannelEntry) *UpgradeGraph {
+	graph := &UpgradeGraph{
+		Package: packageName,
+		Channel: channelName,
+		Head:    head,
+	}
+
+	versions := make(map[string]semver.Version)
+	for _, entry := range entries {
+		graph.Nodes = append(graph.Nodes, GraphNode{Name: entry.Name, Version: entry.Version})
+		if v, err := semver.ParseTolerant(entry.Version); err == nil {
+			versions[entry.Name] = v
+		}
+	}
+
+	// Every bundle in a channel but the first must replace or skip
+	// another, so if none do, the source didn't tell us.
+	graph.EdgesUnknown = len(entries) > 1
+	for _, entry := range entries {
+		if entry.Replaces != "" || len(entry.Skips) > 0 || entry.SkipRange != "" {
+			graph.EdgesUnknown = false
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Replaces != "" {
+			graph.Edges = append(graph.Edges, GraphEdge{From: entry.Replaces, To: entry.Name, Type: EdgeReplaces})
+		}
+
+		for _, skip := range entry.Skips {
+			graph.Edges = append(graph.Edges, GraphEdge{From: skip, To: entry.Name, Type: EdgeSkips})
+		}
+
+		if entry.SkipRange == "" {
+			continue
+		}
+
+		skipRange, err := semver.ParseRange(entry.SkipRange)
+		if err != nil {
+			continue
+		}
+
+		for _, other := range entries {
+			if v, ok := versions[other.Name]; ok && other.Name != entry.Name && other.Name != entry.Replaces && skipRange(v) {
+				graph.Edges = append(graph.Edges, GraphEdge{From: other.Name, To: entry.Name, Type: EdgeSkipRange})
+			}
+		}
+	}
+
+	sort.SliceStable(graph.Nodes, func(i, j int) bool {
+		vi, oki := versions[graph.Nodes[i].Name]
+		vj, okj := versions[graph.Nodes[j].Name]
+		if oki && okj {
+			return vi.LT(vj)
+		}
+		return false
+	})
+
+	return graph
+}
+
+// Return true if the graph contains the named bundle.
+func (graph *UpgradeGraph) HasNode(name string) bool {
+	for _, node := range graph.Nodes {
+		if node.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the sequence of upgrades OLM would make from the named bundle
+// to the head of the channel. At each step OLM upgrades to the newest
+// bundle that replaces or skips the installed one (or whose skipRange
+// includes it), so we do the same; this is not necessarily the shortest
+// path. The result includes both the starting bundle and the channel
+// head.
+func (graph *UpgradeGraph) PathToHead(from string) ([]string, error) {
+	if !graph.HasNode(from) {
+		return nil, fmt.Errorf("%s is not in channel %s", from, graph.Channel)
+	}
+
+	if graph.EdgesUnknown {
+		return nil, fmt.Errorf("no upgrade path from %s to %s: %w", from, graph.Head, ErrEdgesUnknown)
+	}
+
+	versions := make(map[string]semver.Version)
+	for _, node := range graph.Nodes {
+		if v, err := semver.ParseTolerant(node.Version); err == nil {
+			versions[node.Name] = v
+		}
+	}
+
+	// Bundles without a version we can parse are never preferred.
+	newer := func(a, b string) bool {
+		va, oka := versions[a]
+		vb, okb := versions[b]
+		return oka && (!okb || va.GT(vb))
+	}
+
+	upgrades := make(map[string]string)
+	for _, edge := range graph.Edges {
+		if next, ok := upgrades[edge.From]; !ok || newer(edge.To, next) {
+			upgrades[edge.From] = edge.To
+		}
+	}
+
+	path := []string{from}
+	for node := from; node != graph.Head; {
+		next, ok := upgrades[node]
+		if !ok {
+			return nil, fmt.Errorf("no upgrade path from %s to %s (nothing replaces %s)", from, graph.Head, node)
+		}
+
+		for _, seen := range path {
+			if seen == next {
+				return nil, fmt.Errorf("no upgrade path from %s to %s (upgrades loop at %s)", from, graph.Head, next)
+			}
+		}
+
+		path = append(path, next)
+		node = next
+	}
+
+	return path, nil
+}
+
+// Return the upgrade graph for a channel in the given package, which may
+// be qualified with a catalog source (see FindPackageManifest). If
+// channelName is empty, use the default channel.
+func (pm *PackageManager) GetUpgradeGraph(ctx context.Context, ref, channelName string) (*UpgradeGraph, error) {
+	graphSource, ok := pm.source.(GraphSource)
+	if !ok {
+		return nil, fmt.Errorf("package source does not provide channel entries")
+	}
+
+	pkg, err := pm.FindPackageManifest(ctx, ref, "")
+	if err != nil {
+		return nil, err
+	}
+	packageName := pkg.Name
+
+	if channelName == "" {
+		channelName = pkg.GetDefaultChannelName()
+	}
+
+	channel, err := pkg.GetChannelByName(channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getCached(ctx, pm, fmt.Sprintf("channelentries/%s/%s/%s", pkg.Status.CatalogSource, packageName, channelName),
+		func(ctx context.Context) ([]ChannelEntry, error) {
+			return graphSource.GetChannelEntries(ctx, packageName, channelName)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewUpgradeGraph(packageName, channelName, channel.CurrentCSV, entries), nil
+}
+
+// Extract the entries for a channel from the JSON representation of a
+// PackageManifest. The packageserver does not tell us about replaces or
+// skips, so the entries have only names and versions.
+func channelEntriesFromManifest(data []byte, channelName string) ([]ChannelEntry, error) {
+	var manifest packageManifestEntries
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	for _, channel := range manifest.Status.Channels {
+		if channel.Name != channelName {
+			continue
+		}
+
+		if len(channel.Entries) == 0 {
+			return nil, fmt.Errorf("no entries for channel %s (the packageserver may be too old to provide them)", channelName)
+		}
+
+		return channel.Entries, nil
+	}
+
+	return nil, fmt.Errorf("channel %s not found", channelName)
+}
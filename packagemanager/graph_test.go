@@ -0,0 +1,84 @@
+package packagemanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUpgradeGraphFromManifestHasNoEdges(t *testing.T) {
+	data := []byte(`{"status":{"channels":[{"name":"stable","entries":[
+		{"name":"etcd.v0.9.4","version":"0.9.4"},
+		{"name":"etcd.v0.9.2","version":"0.9.2"},
+		{"name":"etcd.v0.9.0","version":"0.9.0"}]}]}}`)
+
+	entries, err := channelEntriesFromManifest(data, "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	graph := NewUpgradeGraph("etcd", "stable", "etcd.v0.9.4", entries)
+	if !graph.EdgesUnknown || len(graph.Edges) != 0 || len(graph.Nodes) != 3 {
+		t.Fatalf("expected three nodes and unknown edges, got %+v", graph)
+	}
+
+	if _, err := graph.PathToHead("etcd.v0.9.0"); !errors.Is(err, ErrEdgesUnknown) {
+		t.Errorf("expected ErrEdgesUnknown, got %v", err)
+	}
+}
+
+func TestUpgradeGraphPathToHead(t *testing.T) {
+	graph := NewUpgradeGraph("etcd", "stable", "etcd.v0.9.4", []ChannelEntry{
+		{Name: "etcd.v0.9.4", Version: "0.9.4", Replaces: "etcd.v0.9.2", SkipRange: "<0.9.2"},
+		{Name: "etcd.v0.9.2", Version: "0.9.2", Replaces: "etcd.v0.9.0"},
+		{Name: "etcd.v0.9.0", Version: "0.9.0"},
+	})
+
+	if graph.EdgesUnknown {
+		t.Fatal("edges reported as unknown")
+	}
+
+	path, err := graph.PathToHead("etcd.v0.9.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"etcd.v0.9.0", "etcd.v0.9.4"}; !reflect.DeepEqual(path, expected) {
+		t.Errorf("expected %v, got %v", expected, path)
+	}
+
+	// OLM takes the newest upgrade at each step, even when another
+	// would reach the head sooner.
+	graph = NewUpgradeGraph("widget", "stable", "widget.v5", []ChannelEntry{
+		{Name: "widget.v5", Version: "5.0.0", Replaces: "widget.v4", SkipRange: ">=2.0.0 <3.0.0"},
+		{Name: "widget.v4", Version: "4.0.0", Replaces: "widget.v3"},
+		{Name: "widget.v3", Version: "3.0.0", Skips: []string{"widget.v1"}},
+		{Name: "widget.v2", Version: "2.0.0", Replaces: "widget.v1"},
+		{Name: "widget.v1", Version: "1.0.0"},
+	})
+
+	path, err = graph.PathToHead("widget.v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"widget.v1", "widget.v3", "widget.v4", "widget.v5"}; !reflect.DeepEqual(path, expected) {
+		t.Errorf("expected %v, got %v", expected, path)
+	}
+
+	// ...and gets stuck if the newest upgrade leads nowhere.
+	graph = NewUpgradeGraph("widget", "stable", "widget.v4", []ChannelEntry{
+		{Name: "widget.v4", Version: "4.0.0", Replaces: "widget.v2"},
+		{Name: "widget.v3", Version: "3.0.0", Skips: []string{"widget.v1"}},
+		{Name: "widget.v2", Version: "2.0.0", Replaces: "widget.v1"},
+		{Name: "widget.v1", Version: "1.0.0"},
+	})
+
+	if _, err := graph.PathToHead("widget.v1"); err == nil {
+		t.Error("expected no upgrade path from widget.v1")
+	}
+
+	// A single bundle needs no edges.
+	single := NewUpgradeGraph("etcd", "stable", "etcd.v0.9.4", []ChannelEntry{{Name: "etcd.v0.9.4"}})
+	if single.EdgesUnknown {
+		t.Error("edges of a single-bundle channel reported as unknown")
+	}
+}
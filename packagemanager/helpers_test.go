@@ -0,0 +1,31 @@
+package packagemanager
+
+import (
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+// Return a package from the given catalog source with a single channel,
+// "stable", whose head is head.
+func testPackage(namespace, catalog, name, head string) operators.PackageManifest {
+	var pkg operators.PackageManifest
+
+	pkg.Name = name
+	pkg.Status.CatalogSource = catalog
+	pkg.Status.CatalogSourceNamespace = namespace
+	pkg.Status.DefaultChannel = "stable"
+	pkg.Status.Channels = []operators.PackageChannel{{Name: "stable", CurrentCSV: head}}
+
+	return pkg
+}
+
+// Return the names of the given packages, in order.
+func packageNames(pkgs []operators.PackageManifest) string {
+	var names []string
+	for _, pkg := range pkgs {
+		names = append(names, pkg.Name)
+	}
+
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,371 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+type (
+	// Rather than caching the list of packages as a single value, we
+	// store each package under its own key (see packageCacheKey) and
+	// cache a packageIndex that records the keys of all packages in
+	// the order the Source returned them. Secondary indexes map a
+	// lowercase value (a keyword, catalog source, etc) to the keys of
+	// the packages that have it, so that we can find the packages
+	// matching a filter without loading all of them.
+	packageIndex struct {
+		Packages       []string            `json:"packages"`
+		Names          map[string][]string `json:"names"`
+		Keywords       map[string][]string `json:"keywords"`
+		CatalogSources map[string][]string `json:"catalogSources"`
+		Providers      map[string][]string `json:"providers"`
+		OwnedAPIs      map[string][]string `json:"ownedAPIs"`
+
+		// Packages we have already loaded, keyed by cache key.
+		loaded map[string]*operators.PackageManifest
+	}
+
+	// An indexedFilter is a filter that can use the package index to
+	// find the packages it might match. lookup returns a superset of
+	// the matching packages; we still apply the filter to each one.
+	indexedFilter struct {
+		FilterFunc
+		lookup func(idx *packageIndex) map[string]bool
+	}
+)
+
+// The cache key of the package index.
+const packageIndexKey = "packageindex"
+
+// Returned when the index refers to a package that is not in the cache.
+var errPackageNotCached = errors.New("package is missing from the cache")
+
+// Return the key under which we cache a single package. This includes
+// both namespaces so that packages remain distinct when listing across
+// all namespaces.
+func packageCacheKey(pkg *operators.PackageManifest) string {
+	return strings.Join([]string{
+		"package",
+		pkg.Namespace,
+		pkg.Status.CatalogSourceNamespace,
+		pkg.Status.CatalogSource,
+		pkg.Name,
+	}, "/")
+}
+
+// Owned APIs are indexed as name/group/version/kind.
+func ownedAPIIndexValue(name, group, version, kind string) string {
+	return strings.ToLower(strings.Join([]string{name, group, version, kind}, "/"))
+}
+
+func parseOwnedAPIIndexValue(value string) (name, group, version, kind string) {
+	parts := strings.SplitN(value, "/", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+
+	return parts[0], parts[1], parts[2], parts[3]
+}
+
+// Build an index of the given packages.
+func newPackageIndex(pkgs []operators.PackageManifest) *packageIndex {
+	idx := &packageIndex{
+		Names:          make(map[string][]string),
+		Keywords:       make(map[string][]string),
+		CatalogSources: make(map[string][]string),
+		Providers:      make(map[string][]string),
+		OwnedAPIs:      make(map[string][]string),
+		loaded:         make(map[string]*operators.PackageManifest),
+	}
+
+	for i := range pkgs {
+		pkg := &pkgs[i]
+		key := packageCacheKey(pkg)
+		if _, exists := idx.loaded[key]; exists {
+			continue
+		}
+
+		idx.Packages = append(idx.Packages, key)
+		idx.loaded[key] = pkg
+
+		idx.add(idx.Names, pkg.Name, key)
+		idx.add(idx.CatalogSources, pkg.Status.CatalogSource, key)
+		idx.add(idx.CatalogSources, pkg.Status.CatalogSourceDisplayName, key)
+		idx.add(idx.Providers, pkg.Status.Provider.Name, key)
+
+		for _, channel := range pkg.Status.Channels {
+			for _, keyword := range channel.CurrentCSVDesc.Keywords {
+				idx.add(idx.Keywords, keyword, key)
+			}
+
+			for _, crd := range channel.CurrentCSVDesc.CustomResourceDefinitions.Owned {
+				_, group, _ := strings.Cut(crd.Name, ".")
+				idx.add(idx.OwnedAPIs, ownedAPIIndexValue(crd.Name, group, crd.Version, crd.Kind), key)
+			}
+
+			for _, apiservice := range channel.CurrentCSVDesc.APIServiceDefinitions.Owned {
+				name := apiservice.Name + "." + apiservice.Group
+				idx.add(idx.OwnedAPIs, ownedAPIIndexValue(name, apiservice.Group, apiservice.Version, apiservice.Kind), key)
+			}
+		}
+	}
+
+	return idx
+}
+
+// Record that the package with the given key has value.
+func (idx *packageIndex) add(index map[string][]string, value, key string) {
+	if value == "" {
+		return
+	}
+
+	value = strings.ToLower(value)
+	keys := index[value]
+
+	// We index packages one at a time, so a duplicate can only be the
+	// last key.
+	if len(keys) > 0 && keys[len(keys)-1] == key {
+		return
+	}
+
+	index[value] = append(keys, key)
+}
+
+// Return the keys of packages having any of the given values.
+func (idx *packageIndex) lookup(index map[string][]string, values ...string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, value := range values {
+		for _, key := range index[strings.ToLower(value)] {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// Return the keys of packages having any value selected by match.
+func (idx *packageIndex) scan(index map[string][]string, match func(value string) bool) map[string]bool {
+	keys := make(map[string]bool)
+	for value, valueKeys := range index {
+		if !match(value) {
+			continue
+		}
+
+		for _, key := range valueKeys {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// Return the keys of the packages that the filter might match, or false
+// if the index can't tell us.
+func (idx *packageIndex) candidates(filter PackageManifestFilter) (map[string]bool, bool) {
+	switch filter := filter.(type) {
+	case indexedFilter:
+		return filter.lookup(idx), true
+	case allFilter:
+		var keys map[string]bool
+		for _, f := range filter {
+			fkeys, ok := idx.candidates(f)
+			if !ok {
+				continue
+			}
+
+			if keys == nil {
+				keys = fkeys
+				continue
+			}
+
+			for key := range keys {
+				if !fkeys[key] {
+					delete(keys, key)
+				}
+			}
+		}
+
+		return keys, keys != nil
+	case anyFilter:
+		keys := make(map[string]bool)
+		for _, f := range filter {
+			fkeys, ok := idx.candidates(f)
+			if !ok {
+				return nil, false
+			}
+
+			for key := range fkeys {
+				keys[key] = true
+			}
+		}
+
+		return keys, true
+	}
+
+	return nil, false
+}
+
+// Return the keys of the packages that might match all of the filters,
+// in index order.
+func (idx *packageIndex) selectKeys(filters []PackageManifestFilter) []string {
+	candidates, ok := idx.candidates(allFilter(filters))
+	if !ok {
+		return idx.Packages
+	}
+
+	var keys []string
+	for _, key := range idx.Packages {
+		if candidates[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Load a single package, from memory if we fetched it ourselves or
+// otherwise from the cache. Packages are valid for as long as the index
+// that refers to them, so we ignore their age.
+func (pm *PackageManager) loadPackage(idx *packageIndex, key string) (*operators.PackageManifest, error) {
+	if pkg, ok := idx.loaded[key]; ok {
+		return pkg, nil
+	}
+
+	value, err := pm.cache.GetStale(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if value == nil {
+		return nil, fmt.Errorf("%s: %w", key, errPackageNotCached)
+	}
+
+	var pkg operators.PackageManifest
+	if err := json.Unmarshal(value.Data, &pkg); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	if idx.loaded == nil {
+		idx.loaded = make(map[string]*operators.PackageManifest)
+	}
+	idx.loaded[key] = &pkg
+
+	return &pkg, nil
+}
+
+// Store each package in the index under its own key, and delete packages
+// that were in the previous index but are no longer available.
+func (pm *PackageManager) storePackages(idx *packageIndex) {
+	values := make(map[string][]byte)
+	for key, pkg := range idx.loaded {
+		data, err := json.Marshal(pkg)
+		if err != nil {
+			log.Printf("failed to encode value for cache: %v", err)
+			continue
+		}
+		values[key] = data
+	}
+
+	if err := pm.cache.PutMany(values); err != nil {
+		log.Printf("cache store failed: %v", err)
+	}
+
+	if oldIdx, _, ok := getStale[*packageIndex](pm, packageIndexKey); ok {
+		for _, key := range oldIdx.Packages {
+			if _, ok := idx.loaded[key]; ok {
+				continue
+			}
+
+			if err := pm.cache.Delete(key); err != nil {
+				log.Printf("cache delete failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reset the age of the packages in the cached index, which is about to be
+// refreshed because the Source reported no changes. Otherwise the
+// packages would expire (and be pruned from the cache) while the index
+// still refers to them. Returns false if any of them are missing.
+func (pm *PackageManager) refreshPackages() bool {
+	idx, _, ok := getStale[*packageIndex](pm, packageIndexKey)
+	if !ok {
+		return false
+	}
+
+	values := make(map[string][]byte, len(idx.Packages))
+	for _, key := range idx.Packages {
+		value, err := pm.cache.GetStale(key)
+		if err != nil || value == nil {
+			return false
+		}
+		values[key] = value.Data
+	}
+
+	if err := pm.cache.PutMany(values); err != nil {
+		log.Printf("cache store failed: %v", err)
+	}
+
+	return true
+}
+
+// Get the package index, fetching the list of packages from the Source
+// if necessary.
+func (pm *PackageManager) getPackageIndex(ctx context.Context) (*packageIndex, error) {
+	list := func(ctx context.Context, _ string) ([]operators.PackageManifest, string, error) {
+		pkgs, err := pm.source.ListPackageManifests(ctx)
+		return pkgs, "", err
+	}
+	if conditionalSource, ok := pm.source.(ConditionalSource); ok {
+		list = conditionalSource.ListPackageManifestsIfModified
+	}
+
+	return getCachedVersioned(ctx, pm, packageIndexKey,
+		func(ctx context.Context, version string) (*packageIndex, string, error) {
+			pkgs, newVersion, err := list(ctx, version)
+			if errors.Is(err, ErrNotModified) {
+				if pm.refreshPackages() {
+					return nil, version, err
+				}
+
+				// Some packages have gone missing from the cache, so
+				// the index is no use to us.
+				pkgs, newVersion, err = list(ctx, "")
+			}
+			if err != nil {
+				return nil, "", err
+			}
+
+			idx := newPackageIndex(pkgs)
+			pm.storePackages(idx)
+
+			return idx, newVersion, nil
+		})
+}
+
+// Return the package index if it is in the cache and has not expired (or
+// regardless of age in offline mode), without contacting the Source.
+func (pm *PackageManager) cachedPackageIndex() *packageIndex {
+	if pm.cachePolicy == CacheOffline {
+		idx, _, _ := getStale[*packageIndex](pm, packageIndexKey)
+		return idx
+	}
+
+	data, err := pm.cache.Get(packageIndexKey)
+	if err != nil || data == nil {
+		return nil
+	}
+
+	var idx *packageIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil
+	}
+
+	return idx
+}
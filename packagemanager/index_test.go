@@ -0,0 +1,190 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"kola/cache"
+	"strings"
+	"testing"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+// A Source that counts how often it is asked for the list of packages.
+type listingSource struct {
+	NullSource
+
+	pkgs  []operators.PackageManifest
+	lists int
+}
+
+func (src *listingSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	src.lists++
+	return src.pkgs, nil
+}
+
+// Return the names of the packages with the given keys, in order.
+func indexKeyNames(keys []string) string {
+	var names []string
+	for _, key := range keys {
+		names = append(names, key[strings.LastIndex(key, "/")+1:])
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestNewPackageIndex(t *testing.T) {
+	// A package listed twice is only indexed once.
+	pkgs := append([]operators.PackageManifest{}, queryTestPackages...)
+	pkgs = append(pkgs, queryTestPackages[0])
+	idx := newPackageIndex(pkgs)
+
+	if len(idx.Packages) != len(queryTestPackages) {
+		t.Errorf("expected %d packages, got %v", len(queryTestPackages), idx.Packages)
+	}
+
+	for _, test := range []struct {
+		index    map[string][]string
+		value    string
+		expected string
+	}{
+		{idx.Names, "flux", "flux"},
+		{idx.Keywords, "gitops", "flux,argocd-operator,openshift-gitops"},
+		{idx.CatalogSources, "redhat", "openshift-gitops"},
+		{idx.Providers, "red hat", "openshift-gitops"},
+	} {
+		if names := indexKeyNames(test.index[test.value]); names != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.value, test.expected, names)
+		}
+	}
+}
+
+func TestSelectKeys(t *testing.T) {
+	idx := newPackageIndex(queryTestPackages)
+
+	for _, test := range []struct {
+		name     string
+		filters  []PackageManifestFilter
+		expected string
+	}{
+		{
+			"no filters",
+			nil,
+			"flux,argocd-operator,openshift-gitops,etcd,my operator",
+		},
+		{
+			"all indexed",
+			[]PackageManifestFilter{MatchKeywords([]string{"gitops"}), MatchCatalogSource("community")},
+			"flux,argocd-operator",
+		},
+		{
+			"all with an unindexed filter",
+			[]PackageManifestFilter{MatchCertified(true), MatchProvider("argo")},
+			"argocd-operator",
+		},
+		{
+			"any indexed",
+			[]PackageManifestFilter{MatchAny(MatchPackageName("etcd"), MatchProvider("argo"))},
+			"argocd-operator,etcd",
+		},
+		{
+			"any with an unindexed filter",
+			[]PackageManifestFilter{MatchAny(MatchPackageName("etcd"), MatchCertified(true))},
+			"flux,argocd-operator,openshift-gitops,etcd,my operator",
+		},
+		{
+			"nested",
+			[]PackageManifestFilter{MatchAll(MatchKeywords([]string{"gitops"}), MatchAny(MatchProvider("red hat"), MatchPackageGlobs("fl*")))},
+			"flux,openshift-gitops",
+		},
+	} {
+		if names := indexKeyNames(idx.selectKeys(test.filters)); names != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, names)
+		}
+	}
+}
+
+func TestStorePackages(t *testing.T) {
+	c := cache.NewLRUCache(0)
+	pm := NewPackageManager(&NullSource{}).WithCache(c)
+
+	// The first index is stored as getPackageIndex would.
+	oldIdx := newPackageIndex(queryTestPackages)
+	pm.storePackages(oldIdx)
+	data, err := json.Marshal(oldIdx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(packageIndexKey, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packages that are no longer available are removed.
+	newIdx := newPackageIndex(queryTestPackages[1:])
+	pm.storePackages(newIdx)
+
+	for i := range queryTestPackages {
+		key := packageCacheKey(&queryTestPackages[i])
+		data, err := c.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := data != nil; stored != (i > 0) {
+			t.Errorf("%s: stored is %t", key, stored)
+		}
+	}
+}
+
+func TestRefreshPackages(t *testing.T) {
+	c := cache.NewLRUCache(0)
+	src := &listingSource{pkgs: queryTestPackages}
+	pm := NewPackageManager(src).WithCache(c)
+
+	if pm.refreshPackages() {
+		t.Error("refreshed packages without an index")
+	}
+
+	if _, err := pm.ListPackageManifests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !pm.refreshPackages() {
+		t.Error("failed to refresh a complete index")
+	}
+
+	if err := c.Delete(packageCacheKey(&queryTestPackages[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	if pm.refreshPackages() {
+		t.Error("refreshed an index with a missing package")
+	}
+}
+
+func TestListPackageManifestsRefetchesMissingPackages(t *testing.T) {
+	c := cache.NewLRUCache(0)
+	src := &listingSource{pkgs: queryTestPackages}
+	pm := NewPackageManager(src).WithCache(c)
+
+	if _, err := pm.ListPackageManifests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is still current, but one of the packages it refers to
+	// has gone.
+	if err := c.Delete(packageCacheKey(&queryTestPackages[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := pm.ListPackageManifests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := packageNames(pkgs); names != "flux,argocd-operator,openshift-gitops,etcd,my operator" {
+		t.Errorf("unexpected packages %s", names)
+	}
+	if src.lists != 2 {
+		t.Errorf("expected two lists, got %d", src.lists)
+	}
+}
@@ -0,0 +1,277 @@
+package packagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type (
+	// A KubeSource reads PackageManifests from the packageserver
+	// aggregated API in a remote Kubernetes instance.
+	KubeSource struct {
+		clientset *kubernetes.Clientset
+		namespace string
+	}
+)
+
+const (
+	packagesAPIPath    = "/apis/packages.operators.coreos.com/v1"
+	catalogSourcesPath = "/apis/operators.coreos.com/v1alpha1/catalogsources"
+)
+
+// Create a new KubeSource. By default we query PackageManifests in the
+// default namespace, which includes packages from global catalog sources
+// as well as any catalog sources in the default namespace.
+func NewKubeSource(clientset *kubernetes.Clientset) *KubeSource {
+	return &KubeSource{
+		clientset: clientset,
+		namespace: "default",
+	}
+}
+
+// Query PackageManifests visible in the given namespace. This includes
+// packages from global catalog sources and packages from catalog sources
+// in that namespace. An empty namespace queries all namespaces.
+func (src *KubeSource) WithNamespace(namespace string) *KubeSource {
+	src.namespace = namespace
+	return src
+}
+
+// Return the path to the PackageManifests collection for our namespace.
+func (src *KubeSource) packageManifestsPath() string {
+	if src.namespace == "" {
+		return packagesAPIPath + "/packagemanifests"
+	}
+
+	return fmt.Sprintf("%s/namespaces/%s/packagemanifests", packagesAPIPath, src.namespace)
+}
+
+// GET a path from Kubernetes and unmarshal the response into v.
+func (src *KubeSource) get(ctx context.Context, path string, v interface{}) error {
+	data, err := src.getRaw(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// GET a path from Kubernetes and return the response body.
+func (src *KubeSource) getRaw(ctx context.Context, path string) ([]byte, error) {
+	return src.clientset.RESTClient().Get().AbsPath(path).DoRaw(ctx)
+}
+
+// GET a single PackageManifest and return the response body. There is
+// no way to GET a package by name across all namespaces, so in that case
+// we list all packages and pick out the first one with a matching name.
+func (src *KubeSource) getRawPackageManifest(ctx context.Context, packageName string) ([]byte, error) {
+	if src.namespace != "" {
+		return src.getRaw(ctx, fmt.Sprintf("%s/%s", src.packageManifestsPath(), packageName))
+	}
+
+	var pkgs struct {
+		Items []json.RawMessage `json:"items"`
+	}
+
+	if err := src.get(ctx, src.packageManifestsPath(), &pkgs); err != nil {
+		return nil, err
+	}
+
+	for _, item := range pkgs.Items {
+		var pkg struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+
+		if err := json.Unmarshal(item, &pkg); err != nil {
+			return nil, err
+		}
+
+		if pkg.Metadata.Name == packageName {
+			return item, nil
+		}
+	}
+
+	return nil, fmt.Errorf("package %s not found", packageName)
+}
+
+func (src *KubeSource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	var pkg operators.PackageManifest
+
+	data, err := src.getRawPackageManifest(ctx, packageName)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+
+	return &pkg, nil
+}
+
+func (src *KubeSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	var pkgs operators.PackageManifestList
+
+	if err := src.get(ctx, src.packageManifestsPath(), &pkgs); err != nil {
+		return nil, err
+	}
+
+	return pkgs.Items, nil
+}
+
+// GET a list from Kubernetes and return its resourceVersion, reading
+// no further into the response than the list metadata. The server
+// normally sends the metadata before the items, so even if it ignores
+// the limit we ask for we stop reading (and close the connection) before
+// the items arrive.
+func (src *KubeSource) getListVersion(ctx context.Context, path string) (string, error) {
+	stream, err := src.clientset.RESTClient().Get().
+		AbsPath(path).
+		Param("limit", "1").
+		Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	decoder := json.NewDecoder(stream)
+	if tok, err := decoder.Token(); err != nil {
+		return "", err
+	} else if tok != json.Delim('{') {
+		return "", fmt.Errorf("unexpected list response from %s", path)
+	}
+
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		if tok != "metadata" {
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		var meta metav1.ListMeta
+		if err := decoder.Decode(&meta); err != nil {
+			return "", err
+		}
+		return meta.ResourceVersion, nil
+	}
+
+	return "", nil
+}
+
+// List PackageManifests only if the list has changed since version, the
+// resourceVersion of a previous list. The full list of packages can be
+// large, so we first read just the metadata of the list to learn its
+// current version, and only fetch the whole thing if it differs. If the
+// server doesn't report a resourceVersion there is nothing to compare,
+// so version is empty and we fetch the full list with a single request.
+func (src *KubeSource) ListPackageManifestsIfModified(ctx context.Context, version string) ([]operators.PackageManifest, string, error) {
+	if version != "" {
+		newVersion, err := src.getListVersion(ctx, src.packageManifestsPath())
+		if err != nil {
+			return nil, "", err
+		}
+
+		if newVersion == version {
+			return nil, version, ErrNotModified
+		}
+	}
+
+	var pkgs operators.PackageManifestList
+
+	if err := src.get(ctx, src.packageManifestsPath(), &pkgs); err != nil {
+		return nil, "", err
+	}
+
+	return pkgs.Items, pkgs.ResourceVersion, nil
+}
+
+func (src *KubeSource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	data, err := src.getRawPackageManifest(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return channelEntriesFromManifest(data, channelName)
+}
+
+func (src *KubeSource) WatchPackageManifests(ctx context.Context, fn func(eventType string, pkg *operators.PackageManifest) error) error {
+	stream, err := src.clientset.RESTClient().Get().
+		AbsPath(src.packageManifestsPath()).
+		Param("watch", "true").
+		Stream(ctx)
+	if err != nil {
+		if apierrors.IsMethodNotSupported(err) || apierrors.IsNotFound(err) {
+			return ErrWatchUnsupported
+		}
+		return err
+	}
+	defer stream.Close()
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var pkg operators.PackageManifest
+			if err := json.Unmarshal(event.Object, &pkg); err != nil {
+				return err
+			}
+			if err := fn(event.Type, &pkg); err != nil {
+				return err
+			}
+		case "ERROR":
+			var status metav1.Status
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return err
+			}
+			return apierrors.FromObject(&status)
+		}
+	}
+}
+
+func (src *KubeSource) GetCatalogPriorities(ctx context.Context) (map[string]int, error) {
+	var catalogs operatorsv1alpha1.CatalogSourceList
+
+	if err := src.get(ctx, catalogSourcesPath, &catalogs); err != nil {
+		return nil, err
+	}
+
+	priorities := make(map[string]int)
+	for _, catalog := range catalogs.Items {
+		priorities[catalog.Namespace+"/"+catalog.Name] = catalog.Spec.Priority
+	}
+
+	return priorities, nil
+}
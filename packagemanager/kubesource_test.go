@@ -0,0 +1,146 @@
+package packagemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// A fake packageserver serving a list of two packages at the given
+// resourceVersion. If ignoreLimit is set it sends the full list whatever
+// limit it is asked for, and after the list metadata it stalls until the
+// client goes away, so a client that reads the whole response never
+// finishes.
+type fakePackageServer struct {
+	version     string
+	ignoreLimit bool
+
+	mu       sync.Mutex
+	requests []string
+	release  chan struct{}
+}
+
+func (srv *fakePackageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	srv.requests = append(srv.requests, r.URL.RawQuery)
+	srv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"kind":"PackageManifestList","apiVersion":"packages.operators.coreos.com/v1","metadata":{"resourceVersion":%q},"items":[`, srv.version)
+
+	if srv.ignoreLimit {
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-srv.release:
+		}
+		return
+	}
+
+	fmt.Fprint(w, `{"metadata":{"name":"widget"}}`)
+	if r.URL.Query().Get("limit") == "" {
+		fmt.Fprint(w, `,{"metadata":{"name":"gadget"}}`)
+	}
+	fmt.Fprint(w, `]}`)
+}
+
+func newFakeKubeSource(t *testing.T, srv *fakePackageServer) *KubeSource {
+	t.Helper()
+
+	srv.release = make(chan struct{})
+	server := httptest.NewServer(srv)
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(srv.release) })
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewKubeSource(clientset)
+}
+
+func TestListPackageManifestsIfModified(t *testing.T) {
+	tests := []struct {
+		name         string
+		server       *fakePackageServer
+		version      string
+		wantErr      error
+		wantVersion  string
+		wantPackages []string
+		wantRequests []string
+	}{
+		{
+			name:         "unchanged",
+			server:       &fakePackageServer{version: "5"},
+			version:      "5",
+			wantErr:      ErrNotModified,
+			wantVersion:  "5",
+			wantRequests: []string{"limit=1"},
+		},
+		{
+			name:         "changed",
+			server:       &fakePackageServer{version: "6"},
+			version:      "5",
+			wantVersion:  "6",
+			wantPackages: []string{"widget", "gadget"},
+			wantRequests: []string{"limit=1", ""},
+		},
+		{
+			name:         "no previous version",
+			server:       &fakePackageServer{version: "6"},
+			wantVersion:  "6",
+			wantPackages: []string{"widget", "gadget"},
+			wantRequests: []string{""},
+		},
+		{
+			name:         "limit ignored",
+			server:       &fakePackageServer{version: "5", ignoreLimit: true},
+			version:      "5",
+			wantErr:      ErrNotModified,
+			wantVersion:  "5",
+			wantRequests: []string{"limit=1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tt.server
+			src := newFakeKubeSource(t, srv)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			pkgs, version, err := src.ListPackageManifestsIfModified(ctx, tt.version)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("got version %q, want %q", version, tt.wantVersion)
+			}
+
+			var names []string
+			for _, pkg := range pkgs {
+				names = append(names, pkg.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantPackages) {
+				t.Errorf("got packages %v, want %v", names, tt.wantPackages)
+			}
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			if !reflect.DeepEqual(srv.requests, tt.wantRequests) {
+				t.Errorf("got requests %q, want %q", srv.requests, tt.wantRequests)
+			}
+		})
+	}
+}
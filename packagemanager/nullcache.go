@@ -1,5 +1,7 @@
 package packagemanager
 
+import "kola/cache"
+
 type (
 	NullCache struct {
 	}
@@ -12,3 +14,27 @@ func (*NullCache) Put(key string, value []byte) error {
 func (*NullCache) Get(key string) ([]byte, error) {
 	return nil, nil
 }
+
+func (*NullCache) GetStale(key string) (*cache.Value, error) {
+	return nil, nil
+}
+
+func (*NullCache) PutWithVersion(key string, value []byte, version string) error {
+	return nil
+}
+
+func (*NullCache) PutMany(values map[string][]byte) error {
+	return nil
+}
+
+func (*NullCache) PutValue(key string, value *cache.Value) error {
+	return nil
+}
+
+func (*NullCache) Delete(key string) error {
+	return nil
+}
+
+func (*NullCache) Entries() ([]cache.Entry, error) {
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+package packagemanager
+
+import (
+	"context"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+type (
+	// A NullSource has no packages of its own. It is used with the
+	// CacheOffline policy to read packages only from a cache (for
+	// example, one imported from another machine).
+	NullSource struct {
+	}
+)
+
+func (*NullSource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	return nil, ErrNotCached
+}
+
+func (*NullSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	return nil, ErrNotCached
+}
+
+func (*NullSource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	return nil, ErrNotCached
+}
@@ -1,35 +1,77 @@
-// Methods for interacting with PackageManifests in a remote Kubernetes
-// instance.
+// Methods for interacting with PackageManifests provided by a Source (a
+// remote Kubernetes instance, a local file, etc).
 package packagemanager
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"kola/cache"
 	"log"
+	"strings"
+	"time"
 
 	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
-	"k8s.io/client-go/kubernetes"
 )
 
 type (
 	PackageManager struct {
-		clientset *kubernetes.Clientset
-		cache     cache.Cache
+		source      Source
+		cache       cache.Cache
+		cachePolicy CachePolicy
+		refreshes   refreshes
 	}
 
-	// A PackageManifestFilter is a method that takes as input a
-	// PackageManifest and returns a boolean indicating whether or not the
-	// package is matched by the filter.
-	PackageManifestFilter func(pkg *operators.PackageManifest) bool
+	// A Source is something from which we can retrieve PackageManifests.
+	Source interface {
+		// Get the PackageManifest for a particular package.
+		GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error)
+
+		// Get all available PackageManifests.
+		ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error)
+	}
+
+	// A ConditionalSource is a Source that can avoid sending the list
+	// of PackageManifests if it has not changed.
+	ConditionalSource interface {
+		// Get all available PackageManifests, along with the version of
+		// the list. If version is not empty and the list has not changed
+		// since that version, return ErrNotModified instead.
+		ListPackageManifestsIfModified(ctx context.Context, version string) ([]operators.PackageManifest, string, error)
+	}
+
+	// A versionedFetch retrieves a value from a Source, returning the
+	// version of the value if the Source knows it. If version is not
+	// empty and the value has not changed since that version, it may
+	// return ErrNotModified.
+	versionedFetch[T any] func(ctx context.Context, version string) (T, string, error)
+
+	// A PackageManifestFilter selects packages. The filters in
+	// filters.go can also tell us how to find their packages in the
+	// package index; use FilterFunc to make a filter from a function.
+	PackageManifestFilter interface {
+		Match(pkg *operators.PackageManifest) bool
+	}
+
+	// A FilterFunc is a PackageManifestFilter implemented by a method
+	// that takes as input a PackageManifest and returns a boolean
+	// indicating whether or not the package is matched by the filter.
+	FilterFunc func(pkg *operators.PackageManifest) bool
 )
 
-// Create a new PackageManager.
-func NewPackageManager(clientset *kubernetes.Clientset) *PackageManager {
+// Returned by a ConditionalSource when nothing has changed.
+var ErrNotModified = errors.New("not modified")
+
+func (fn FilterFunc) Match(pkg *operators.PackageManifest) bool {
+	return fn(pkg)
+}
+
+// Create a new PackageManager that reads packages from the given Source.
+func NewPackageManager(source Source) *PackageManager {
 	return &PackageManager{
-		clientset: clientset,
-		cache:     &NullCache{},
+		source: source,
+		cache:  &NullCache{},
 	}
 }
 
@@ -40,79 +82,196 @@ func (pm *PackageManager) WithCache(cache cache.Cache) *PackageManager {
 	return pm
 }
 
-// GET a path from Kubernetes. If this PackageManager has a Cache, we
-// might retrieve the value from the cache rather than making a request
-// to the server.
-func (pm *PackageManager) getCached(path string) ([]byte, error) {
-	var data []byte
-	var err error
+// Look up key in the cache. If there is no cached value, call fetch to
+// retrieve the value from the Source and store the result in the cache.
+// What happens when the cached value has expired depends on the cache
+// policy (see CachePolicy).
+func getCached[T any](ctx context.Context, pm *PackageManager, key string, fetch func(context.Context) (T, error)) (T, error) {
+	return getCachedVersioned(ctx, pm, key, func(ctx context.Context, _ string) (T, string, error) {
+		val, err := fetch(ctx)
+		return val, "", err
+	})
+}
+
+// Like getCached, but when refreshing an expired value we pass its
+// version to fetch so that the Source can tell us if nothing has changed.
+func getCachedVersioned[T any](ctx context.Context, pm *PackageManager, key string, fetch versionedFetch[T]) (T, error) {
+	var val T
 
-	if data, err = pm.cache.Get(path); err != nil {
+	data, err := pm.cache.Get(key)
+	if err != nil {
 		log.Printf("cache fetch failed: %v", err)
 		data = nil
 	}
 
-	if data == nil {
-		if data, err = pm.clientset.RESTClient().Get().AbsPath(path).DoRaw(context.TODO()); err != nil {
-			return nil, err
+	if data != nil {
+		if err := json.Unmarshal(data, &val); err == nil {
+			return val, nil
+		}
+		log.Printf("failed to decode cached value for %s", key)
+	}
+
+	switch pm.cachePolicy {
+	case CacheOffline:
+		if stale, _, ok := getStale[T](pm, key); ok {
+			return stale, nil
 		}
+		return val, fmt.Errorf("%s: %w", key, ErrNotCached)
+	case CacheStaleWhileRevalidate:
+		if stale, age, ok := getStale[T](pm, key); ok {
+			log.Printf("warning: using cached %s from %s ago while refreshing", key, age.Round(time.Second))
+			pm.refresh(ctx, key, func(ctx context.Context) error {
+				_, err := fetchAndStore(ctx, pm, key, fetch)
+				return err
+			})
+			return stale, nil
+		}
+	}
 
-		if err = pm.cache.Put(path, data); err != nil {
-			log.Printf("cache store failed: %v", err)
+	val, err = fetchAndStore(ctx, pm, key, fetch)
+	if err != nil && pm.cachePolicy == CacheStaleOnError && ctx.Err() == nil {
+		if stale, age, ok := getStale[T](pm, key); ok {
+			log.Printf("warning: using cached %s from %s ago: %v", key, age.Round(time.Second), err)
+			return stale, nil
 		}
 	}
 
-	return data, nil
+	return val, err
 }
 
-// Get the PackageManifest for a particular package.
-func (pm *PackageManager) GetPackageManifest(packageName string) (*Package, error) {
-	var pkg Package
+// Call fetch to retrieve a value from the Source and store the result in
+// the cache. If the Source tells us that the value has not changed since
+// we cached it, we keep the cached value and reset its age.
+func fetchAndStore[T any](ctx context.Context, pm *PackageManager, key string, fetch versionedFetch[T]) (T, error) {
+	var val T
 
-	data, err := pm.getCached(
-		fmt.Sprintf("/apis/packages.operators.coreos.com/v1/namespaces/default/packagemanifests/%s", packageName))
+	stale, err := pm.cache.GetStale(key)
 	if err != nil {
-		return nil, err
+		log.Printf("cache fetch failed: %v", err)
+		stale = nil
 	}
 
-	if err := json.Unmarshal(data, &pkg); err != nil {
-		return nil, err
+	version := ""
+	if stale != nil {
+		version = stale.Version
+	}
+
+	val, newVersion, err := fetch(ctx, version)
+	if errors.Is(err, ErrNotModified) {
+		if err := json.Unmarshal(stale.Data, &val); err == nil {
+			if err := pm.cache.PutWithVersion(key, stale.Data, version); err != nil {
+				log.Printf("cache store failed: %v", err)
+			}
+			return val, nil
+		}
+
+		log.Printf("failed to decode cached value for %s", key)
+		val, newVersion, err = fetch(ctx, "")
+	}
+	if err != nil {
+		return val, err
+	}
+
+	if data, err := json.Marshal(val); err != nil {
+		log.Printf("failed to encode value for cache: %v", err)
+	} else if err = pm.cache.PutWithVersion(key, data, newVersion); err != nil {
+		log.Printf("cache store failed: %v", err)
 	}
-	return &pkg, nil
+
+	return val, nil
 }
 
-// Get all PackageManifests from Kubernetes and return those matching the given
-// set of filters.
-func (pm *PackageManager) ListPackageManifests(filters ...PackageManifestFilter) ([]operators.PackageManifest, error) {
+// Look up key in the cache regardless of age. Returns the value, its age,
+// and true if we found a usable value.
+func getStale[T any](pm *PackageManager, key string) (T, time.Duration, bool) {
+	var val T
 
-	pkgs := &operators.PackageManifestList{}
-	selected := []operators.PackageManifest{}
+	stale, err := pm.cache.GetStale(key)
+	if err != nil {
+		log.Printf("cache fetch failed: %v", err)
+		return val, 0, false
+	}
+
+	if stale == nil {
+		return val, 0, false
+	}
+
+	if err := json.Unmarshal(stale.Data, &val); err != nil {
+		log.Printf("failed to decode cached value for %s", key)
+		return val, 0, false
+	}
+
+	return val, time.Since(stale.Timestamp), true
+}
+
+// Get the PackageManifest for a particular package. If we have a current
+// list of packages in the cache we use that rather than asking the
+// Source.
+func (pm *PackageManager) GetPackageManifest(ctx context.Context, packageName string) (*Package, error) {
+	if idx := pm.cachedPackageIndex(); idx != nil {
+		for _, key := range idx.Names[strings.ToLower(packageName)] {
+			pkg, err := pm.loadPackage(idx, key)
+			if err != nil {
+				break
+			}
+
+			if pkg.Name == packageName {
+				return &Package{*pkg}, nil
+			}
+		}
+	}
 
-	data, err := pm.getCached("/apis/packages.operators.coreos.com/v1/namespaces/default/packagemanifests")
+	manifest, err := getCached(ctx, pm, fmt.Sprintf("packagemanifests/%s", packageName),
+		func(ctx context.Context) (*operators.PackageManifest, error) {
+			return pm.source.GetPackageManifest(ctx, packageName)
+		})
 	if err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(data, pkgs); err != nil {
+	return &Package{*manifest}, nil
+}
+
+// Get all PackageManifests from the Source and return those matching the
+// given set of filters. Filters are combined with AND rather than OR:
+// additional filters make the results *more* specific.
+func (pm *PackageManager) ListPackageManifests(ctx context.Context, filters ...PackageManifestFilter) ([]operators.PackageManifest, error) {
+	for attempt := 0; ; attempt++ {
+		selected, err := pm.listPackageManifests(ctx, filters)
+
+		// If packages have gone missing from the cache, start over
+		// with a fresh list (unless we can't get one).
+		if errors.Is(err, errPackageNotCached) && attempt == 0 && pm.cachePolicy != CacheOffline {
+			log.Printf("cached package list is incomplete: %v", err)
+			if err := pm.cache.Delete(packageIndexKey); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return selected, err
+	}
+}
+
+func (pm *PackageManager) listPackageManifests(ctx context.Context, filters []PackageManifestFilter) ([]operators.PackageManifest, error) {
+	selected := []operators.PackageManifest{}
+
+	idx, err := pm.getPackageIndex(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	// Process filters. Note that additional filters make the results
-	// *more* specific -- that is, filters are combined with AND rather
-	// than OR.
-	if len(filters) > 0 {
-	PACKAGES:
-		for _, pkg := range pkgs.Items {
-			for _, filter := range filters {
-				if !filter(&pkg) {
-					continue PACKAGES
-				}
-			}
+	// The index narrows down the packages we need to look at; we still
+	// apply the filters to each one.
+	for _, key := range idx.selectKeys(filters) {
+		pkg, err := pm.loadPackage(idx, key)
+		if err != nil {
+			return nil, err
+		}
 
-			selected = append(selected, pkg)
+		if matchFilters(pkg, filters) {
+			selected = append(selected, *pkg)
 		}
-	} else {
-		selected = pkgs.Items
 	}
 
 	return selected, nil
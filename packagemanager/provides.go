@@ -0,0 +1,116 @@
+package packagemanager
+
+import (
+	"regexp"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+type (
+	// An APISpec identifies an API by some combination of group,
+	// version and kind, or by CRD name (plural.group). Empty fields
+	// match anything.
+	APISpec struct {
+		Group   string
+		Version string
+		Kind    string
+		Name    string
+	}
+)
+
+// Matches Kubernetes API versions such as v1, v1beta2 or v2alpha1.
+var apiVersionPattern = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+// Parse an API specification, which may be one of:
+//
+//   - group/version/Kind (e.g. etcd.database.coreos.com/v1beta2/EtcdCluster)
+//   - group/version (any Kind)
+//   - group/Kind (any version)
+//   - a CRD name (e.g. etcdclusters.etcd.database.coreos.com)
+//   - a Kind or plural resource name (e.g. EtcdCluster or etcdclusters)
+func ParseAPISpec(s string) APISpec {
+	parts := strings.Split(s, "/")
+
+	switch {
+	case len(parts) >= 3:
+		return APISpec{Group: parts[0], Version: parts[1], Kind: parts[2]}
+	case len(parts) == 2 && apiVersionPattern.MatchString(parts[1]):
+		return APISpec{Group: parts[0], Version: parts[1]}
+	case len(parts) == 2:
+		return APISpec{Group: parts[0], Kind: parts[1]}
+	case strings.Contains(s, "."):
+		return APISpec{Name: s}
+	default:
+		return APISpec{Kind: s}
+	}
+}
+
+// Return true if an API with the given properties matches the spec.
+// Comparisons are case insensitive.
+func (spec APISpec) matches(name, group, version, kind string) bool {
+	if spec.Name != "" {
+		return strings.EqualFold(spec.Name, name)
+	}
+
+	if spec.Group != "" && !strings.EqualFold(spec.Group, group) {
+		return false
+	}
+
+	if spec.Version != "" && !strings.EqualFold(spec.Version, version) {
+		return false
+	}
+
+	if spec.Kind == "" {
+		return true
+	}
+
+	// A bare Kind may also be a plural resource name.
+	plural, _, _ := strings.Cut(name, ".")
+	return strings.EqualFold(spec.Kind, kind) ||
+		(spec.Group == "" && spec.Version == "" && strings.EqualFold(spec.Kind, plural))
+}
+
+// Return true if the CSV at the head of the channel owns an API matching
+// the spec, either as a CustomResourceDefinition or an APIService.
+func (spec APISpec) OwnedBy(channel *operators.PackageChannel) bool {
+	for _, crd := range channel.CurrentCSVDesc.CustomResourceDefinitions.Owned {
+		// CRD names are of the form <plural>.<group>
+		_, group, _ := strings.Cut(crd.Name, ".")
+		if spec.matches(crd.Name, group, crd.Version, crd.Kind) {
+			return true
+		}
+	}
+
+	for _, apiservice := range channel.CurrentCSVDesc.APIServiceDefinitions.Owned {
+		name := apiservice.Name + "." + apiservice.Group
+		if spec.matches(name, apiservice.Group, apiservice.Version, apiservice.Kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return a filter that matches packages with a channel that owns an API
+// matching the given specification (see ParseAPISpec).
+func MatchOwnedAPI(s string) PackageManifestFilter {
+	spec := ParseAPISpec(s)
+	return indexedFilter{
+		FilterFunc: func(pkg *operators.PackageManifest) bool {
+			for i := range pkg.Status.Channels {
+				if spec.OwnedBy(&pkg.Status.Channels[i]) {
+					return true
+				}
+			}
+
+			return false
+		},
+		lookup: func(idx *packageIndex) map[string]bool {
+			return idx.scan(idx.OwnedAPIs, func(value string) bool {
+				name, group, version, kind := parseOwnedAPIIndexValue(value)
+				return spec.matches(name, group, version, kind)
+			})
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package packagemanager
+
+import (
+	"testing"
+
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+func TestParseAPISpec(t *testing.T) {
+	for _, test := range []struct {
+		spec     string
+		expected APISpec
+	}{
+		{"etcd.database.coreos.com/v1beta2/EtcdCluster", APISpec{Group: "etcd.database.coreos.com", Version: "v1beta2", Kind: "EtcdCluster"}},
+		{"etcd.database.coreos.com/v1beta2", APISpec{Group: "etcd.database.coreos.com", Version: "v1beta2"}},
+		{"etcd.database.coreos.com/EtcdCluster", APISpec{Group: "etcd.database.coreos.com", Kind: "EtcdCluster"}},
+		{"etcdclusters.etcd.database.coreos.com", APISpec{Name: "etcdclusters.etcd.database.coreos.com"}},
+		{"EtcdCluster", APISpec{Kind: "EtcdCluster"}},
+	} {
+		if spec := ParseAPISpec(test.spec); spec != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.spec, test.expected, spec)
+		}
+	}
+}
+
+func TestOwnedAPI(t *testing.T) {
+	crdOwner := queryTestPackage("etcd", "community", "CoreOS", false)
+	crdOwner.Status.Channels[0].CurrentCSVDesc.CustomResourceDefinitions.Owned = []operatorsv1alpha1.CRDDescription{
+		{Name: "etcdclusters.etcd.database.coreos.com", Version: "v1beta2", Kind: "EtcdCluster"},
+	}
+
+	apiServiceOwner := queryTestPackage("widgets", "community", "Acme", false)
+	apiServiceOwner.Status.Channels[0].CurrentCSVDesc.APIServiceDefinitions.Owned = []operatorsv1alpha1.APIServiceDescription{
+		{Name: "widgets", Group: "widgets.example.com", Version: "v1alpha1", Kind: "Widget"},
+	}
+
+	pkgs := []operators.PackageManifest{crdOwner, apiServiceOwner}
+	idx := newPackageIndex(pkgs)
+
+	for _, test := range []struct {
+		spec     string
+		expected string
+	}{
+		{"etcdclusters.etcd.database.coreos.com", "etcd"},
+		{"widgets.widgets.example.com", "widgets"},
+		{"EtcdCluster", "etcd"},
+		{"widget", "widgets"},
+		{"etcdclusters", "etcd"},
+		{"widgets", "widgets"},
+		{"etcd.database.coreos.com/EtcdCluster", "etcd"},
+		{"widgets.example.com/Widget", "widgets"},
+		{"etcd.database.coreos.com/v1beta2", "etcd"},
+		{"widgets.example.com/v1alpha1", "widgets"},
+		{"widgets.example.com/v1", ""},
+		{"etcd.database.coreos.com/v1beta2/EtcdCluster", "etcd"},
+		{"widgets.example.com/v1alpha1/Widget", "widgets"},
+		{"etcd.database.coreos.com/v1/EtcdCluster", ""},
+		{"widgets.example.com/v1alpha1/EtcdCluster", ""},
+	} {
+		filter := MatchOwnedAPI(test.spec)
+
+		var matched string
+		for i := range pkgs {
+			if filter.Match(&pkgs[i]) {
+				matched = pkgs[i].Name
+			}
+		}
+		if matched != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.spec, test.expected, matched)
+		}
+
+		// The index must find at least the packages the filter
+		// matches.
+		if names := indexKeyNames(idx.selectKeys([]PackageManifestFilter{filter})); test.expected != "" && names != test.expected {
+			t.Errorf("%s: index expected %q, got %q", test.spec, test.expected, names)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package packagemanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	queryToken struct {
+		value  string
+		quoted bool
+		pos    int
+	}
+
+	// A queryParser parses a query expression, which combines package
+	// filters using boolean operators:
+	//
+	//	keyword:gitops AND (catalog:community OR certified:true) AND NOT provider:acme
+	//
+	// Terms have the form field:value; a term without a field matches
+	// the package name. Values containing spaces or parentheses may be
+	// quoted using double quotes. Operators (AND, OR, NOT) are case
+	// insensitive, NOT binds more tightly than AND, which binds more
+	// tightly than OR, and two terms with no operator between them are
+	// combined with AND.
+	queryParser struct {
+		tokens []queryToken
+		pos    int
+	}
+
+	// A queryField creates a filter from the value of a field:value term.
+	queryField func(value string) (PackageManifestFilter, error)
+)
+
+var queryFields = map[string]queryField{
+	"name": func(value string) (PackageManifestFilter, error) {
+		if strings.ContainsAny(value, "*?[") {
+			return MatchPackageGlobs(value), nil
+		}
+		return MatchPackageSubstrings(value), nil
+	},
+	"keyword": func(value string) (PackageManifestFilter, error) {
+		return MatchKeywords([]string{value}), nil
+	},
+	"catalog": func(value string) (PackageManifestFilter, error) {
+		return MatchCatalogSource(value), nil
+	},
+	"description": func(value string) (PackageManifestFilter, error) {
+		return MatchDescription(value), nil
+	},
+	"installmode": func(value string) (PackageManifestFilter, error) {
+		return MatchInstallMode(value), nil
+	},
+	"provider": func(value string) (PackageManifestFilter, error) {
+		return MatchProvider(value), nil
+	},
+	"api": func(value string) (PackageManifestFilter, error) {
+		return MatchOwnedAPI(value), nil
+	},
+	"certified": func(value string) (PackageManifestFilter, error) {
+		certified, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for certified: %q", value)
+		}
+		return MatchCertified(certified), nil
+	},
+}
+
+// Parse a query expression into a PackageManifestFilter.
+func ParseQuery(query string) (PackageManifestFilter, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	parser := &queryParser{tokens: tokens}
+	filter, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := parser.peek(); tok != nil {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+	}
+
+	return filter, nil
+}
+
+// Split a query into tokens. Parentheses are always tokens on their own;
+// anything else is split on whitespace, except within double quotes.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case runes[i] == '(' || runes[i] == ')':
+			tokens = append(tokens, queryToken{value: string(runes[i]), pos: i})
+			i++
+		default:
+			var value strings.Builder
+			start := i
+			quoted := false
+			inQuotes := false
+
+			for ; i < len(runes); i++ {
+				r := runes[i]
+				if r == '"' {
+					inQuotes = !inQuotes
+					quoted = true
+					continue
+				}
+				if !inQuotes && (unicode.IsSpace(r) || r == '(' || r == ')') {
+					break
+				}
+				value.WriteRune(r)
+			}
+
+			if inQuotes {
+				return nil, fmt.Errorf("unterminated quote at position %d", start)
+			}
+
+			tokens = append(tokens, queryToken{value: value.String(), quoted: quoted, pos: start})
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+// Return true (and consume the token) if the next token is the given
+// operator or parenthesis.
+func (p *queryParser) accept(op string) bool {
+	tok := p.peek()
+	if tok == nil || tok.quoted || !strings.EqualFold(tok.value, op) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// Return true if the next token is the given operator or parenthesis,
+// without consuming it.
+func (p *queryParser) at(op string) bool {
+	tok := p.peek()
+	return tok != nil && !tok.quoted && strings.EqualFold(tok.value, op)
+}
+
+func (p *queryParser) parseOr() (PackageManifestFilter, error) {
+	filter, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []PackageManifestFilter{filter}
+	for p.accept("OR") {
+		filter, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return MatchAny(filters...), nil
+}
+
+func (p *queryParser) parseAnd() (PackageManifestFilter, error) {
+	filter, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []PackageManifestFilter{filter}
+	for {
+		// An explicit AND, or implicit AND when one term directly
+		// follows another.
+		if !p.accept("AND") && (p.peek() == nil || p.at("OR") || p.at(")")) {
+			break
+		}
+
+		filter, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return MatchAll(filters...), nil
+}
+
+func (p *queryParser) parseNot() (PackageManifestFilter, error) {
+	if p.accept("NOT") {
+		filter, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return MatchNot(filter), nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (PackageManifestFilter, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+
+	if p.accept("(") {
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing ) for ( at position %d", tok.pos)
+		}
+		return filter, nil
+	}
+
+	if !tok.quoted {
+		for _, op := range []string{"AND", "OR", "NOT", ")"} {
+			if strings.EqualFold(tok.value, op) {
+				return nil, fmt.Errorf("unexpected %q at position %d", tok.value, tok.pos)
+			}
+		}
+	}
+
+	p.pos++
+	return parseQueryTerm(tok)
+}
+
+// Create a filter from a single field:value term.
+func parseQueryTerm(tok *queryToken) (PackageManifestFilter, error) {
+	field, value, found := strings.Cut(tok.value, ":")
+	if !found {
+		field, value = "name", tok.value
+	}
+
+	makeFilter, ok := queryFields[strings.ToLower(field)]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at position %d", field, tok.pos)
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("missing value for %s at position %d", field, tok.pos)
+	}
+
+	return makeFilter(value)
+}
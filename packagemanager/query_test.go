@@ -0,0 +1,126 @@
+package packagemanager
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+// Return a package with the given provider and keywords.
+func queryTestPackage(name, catalog, provider string, certified bool, keywords ...string) operators.PackageManifest {
+	pkg := testPackage("", catalog, name, name+".v1")
+	pkg.Status.Provider.Name = provider
+
+	channel := &pkg.Status.Channels[0]
+	channel.CurrentCSVDesc.Keywords = keywords
+	channel.CurrentCSVDesc.Annotations = map[string]string{"certified": strconv.FormatBool(certified)}
+
+	return pkg
+}
+
+var queryTestPackages = []operators.PackageManifest{
+	queryTestPackage("flux", "community", "Weaveworks", false, "gitops"),
+	queryTestPackage("argocd-operator", "community", "Argo", false, "gitops", "cd"),
+	queryTestPackage("openshift-gitops", "redhat", "Red Hat", true, "gitops"),
+	queryTestPackage("etcd", "community", "CoreOS", false, "database"),
+	queryTestPackage("my operator", "community", "Acme", false),
+}
+
+// Return the sorted names of the packages matched by a query.
+func queryMatches(t *testing.T, query string) string {
+	t.Helper()
+
+	filter, err := ParseQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+
+	var names []string
+	for i := range queryTestPackages {
+		if filter.Match(&queryTestPackages[i]) {
+			names = append(names, queryTestPackages[i].Name)
+		}
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		// Terms
+		{"flux", "flux"},
+		{"name:argo*", "argocd-operator"},
+		{"keyword:GITOPS", "argocd-operator,flux,openshift-gitops"},
+		{"certified:true", "openshift-gitops"},
+		{"Provider:acme", "my operator"},
+		{`"my operator"`, "my operator"},
+		{`name:"my op"`, "my operator"},
+
+		// AND binds more tightly than OR, and NOT more tightly than
+		// AND.
+		{"keyword:gitops AND catalog:redhat OR etcd", "etcd,openshift-gitops"},
+		{"etcd OR keyword:gitops AND catalog:redhat", "etcd,openshift-gitops"},
+		{"keyword:gitops AND NOT catalog:redhat", "argocd-operator,flux"},
+		{"NOT keyword:gitops AND catalog:community", "etcd,my operator"},
+		{"NOT NOT flux", "flux"},
+
+		// Implicit AND, and case insensitive operators
+		{"keyword:gitops catalog:community", "argocd-operator,flux"},
+		{"flux or etcd", "etcd,flux"},
+		{"keyword:gitops and not flux", "argocd-operator,openshift-gitops"},
+
+		// Parentheses
+		{"keyword:gitops AND (catalog:redhat OR provider:weave)", "flux,openshift-gitops"},
+		{"(etcd OR flux)AND provider:core", "etcd"},
+		{"NOT (keyword:gitops OR etcd)", "my operator"},
+		{"((flux))", "flux"},
+
+		// Quoted operators are terms
+		{`"or"`, "argocd-operator,my operator"},
+		{`flux OR "and"`, "flux"},
+	} {
+		if matches := queryMatches(t, test.query); matches != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.query, test.expected, matches)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{"", "empty query"},
+		{"   ", "empty query"},
+		{"flux AND", "unexpected end of query"},
+		{"OR flux", `unexpected "OR" at position 0`},
+		{"flux OR OR etcd", `unexpected "OR" at position 8`},
+		{"NOT", "unexpected end of query"},
+		{"(flux", "missing ) for ( at position 0"},
+		{"flux)", `unexpected ")" at position 4`},
+		{"()", `unexpected ")" at position 1`},
+		{`"flux`, "unterminated quote at position 0"},
+		{`flux name:"a`, "unterminated quote at position 5"},
+		{"colour:blue", `unknown field "colour" at position 0`},
+		{"flux AND vendor:acme", `unknown field "vendor" at position 9`},
+		{"keyword:", "missing value for keyword at position 0"},
+		{"certified:maybe", `invalid value for certified: "maybe"`},
+	} {
+		_, err := ParseQuery(test.query)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.query)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.query, test.expected, err)
+		}
+	}
+}
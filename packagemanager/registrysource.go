@@ -0,0 +1,201 @@
+package packagemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+	"github.com/operator-framework/operator-registry/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type (
+	// A RegistrySource synthesizes PackageManifests by talking directly to
+	// the operator-registry gRPC API, as served by CatalogSource pods or
+	// by "opm serve".
+	RegistrySource struct {
+		address     string
+		catalog     CatalogInfo
+		dialOptions []grpc.DialOption
+		conn        *grpc.ClientConn
+		client      api.RegistryClient
+	}
+)
+
+// Create a new RegistrySource that connects to the registry at address
+// (host:port). By default the catalog is named after the address.
+func NewRegistrySource(address string) *RegistrySource {
+	return &RegistrySource{
+		address: address,
+		catalog: CatalogInfo{
+			Name:        address,
+			DisplayName: address,
+		},
+	}
+}
+
+// Set the catalog information reported in synthesized PackageManifests.
+func (src *RegistrySource) WithCatalogInfo(catalog CatalogInfo) *RegistrySource {
+	src.catalog = catalog
+	return src
+}
+
+// Pass additional options to grpc.Dial, e.g. to connect through a custom
+// dialer.
+func (src *RegistrySource) WithDialOptions(options ...grpc.DialOption) *RegistrySource {
+	src.dialOptions = append(src.dialOptions, options...)
+	return src
+}
+
+// Return a client for the registry API, connecting if necessary.
+func (src *RegistrySource) registryClient() (api.RegistryClient, error) {
+	if src.client != nil {
+		return src.client, nil
+	}
+
+	options := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, src.dialOptions...)
+	conn, err := grpc.Dial(src.address, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	src.conn = conn
+	src.client = api.NewRegistryClient(conn)
+	return src.client, nil
+}
+
+// Close the connection to the registry.
+func (src *RegistrySource) Close() error {
+	if src.conn == nil {
+		return nil
+	}
+
+	err := src.conn.Close()
+	src.conn = nil
+	src.client = nil
+	return err
+}
+
+// Build a PackageManifest for the named package, in the same way that the
+// packageserver does: by fetching the bundle at the head of each channel.
+func (src *RegistrySource) packageManifest(ctx context.Context, client api.RegistryClient, packageName string) (*operators.PackageManifest, error) {
+	var channels []catalogChannel
+
+	pkg, err := client.GetPackage(ctx, &api.GetPackageRequest{Name: packageName})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range pkg.GetChannels() {
+		bundle, err := client.GetBundleForChannel(ctx, &api.GetBundleInChannelRequest{
+			PkgName:     pkg.GetName(),
+			ChannelName: channel.GetName(),
+		})
+		if err != nil {
+			log.Printf("%s: eliding channel %s: %v", packageName, channel.GetName(), err)
+			continue
+		}
+
+		channels = append(channels, catalogChannel{
+			name:    channel.GetName(),
+			csvJSON: bundle.GetCsvJson(),
+		})
+	}
+
+	return newPackageManifest(src.catalog, pkg.GetName(), pkg.GetDefaultChannelName(), channels)
+}
+
+func (src *RegistrySource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	client, err := src.registryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return src.packageManifest(ctx, client, packageName)
+}
+
+func (src *RegistrySource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	var pkgs []operators.PackageManifest
+	var packageNames []string
+
+	client, err := src.registryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.ListPackages(ctx, &api.ListPackageRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		name, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		packageNames = append(packageNames, name.GetName())
+	}
+
+	for _, packageName := range packageNames {
+		manifest, err := src.packageManifest(ctx, client, packageName)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("skipping package %s: %v", packageName, err)
+			continue
+		}
+		pkgs = append(pkgs, *manifest)
+	}
+
+	return pkgs, nil
+}
+
+func (src *RegistrySource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	var entries []ChannelEntry
+
+	client, err := src.registryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.ListBundles(ctx, &api.ListBundlesRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		bundle, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if bundle.GetPackageName() != packageName || bundle.GetChannelName() != channelName {
+			continue
+		}
+
+		entries = append(entries, ChannelEntry{
+			Name:      bundle.GetCsvName(),
+			Version:   bundle.GetVersion(),
+			Replaces:  bundle.GetReplaces(),
+			Skips:     bundle.GetSkips(),
+			SkipRange: bundle.GetSkipRange(),
+		})
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("channel %s not found", channelName)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,153 @@
+package packagemanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/operator-framework/operator-registry/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// An in-process registry serving a single package, "widget", with two
+// channels. The bundle at the head of "beta" has a CSV we can't decode.
+type fakeRegistry struct {
+	api.UnimplementedRegistryServer
+}
+
+var fakeBundles = []*api.Bundle{
+	{CsvName: "widget.v1", PackageName: "widget", ChannelName: "stable", Version: "1.0.0"},
+	{CsvName: "widget.v2", PackageName: "widget", ChannelName: "stable", Version: "2.0.0", Replaces: "widget.v1", Skips: []string{"widget.v1-rc"}},
+	{CsvName: "widget.v3", PackageName: "widget", ChannelName: "beta", Version: "3.0.0", SkipRange: "<3.0.0"},
+}
+
+func fakeCSV(name string) string {
+	return fmt.Sprintf(`{"apiVersion":"operators.coreos.com/v1alpha1","kind":"ClusterServiceVersion","metadata":{"name":%q},"spec":{"displayName":"Widget","provider":{"name":"Acme"}}}`, name)
+}
+
+func (*fakeRegistry) ListPackages(req *api.ListPackageRequest, stream api.Registry_ListPackagesServer) error {
+	return stream.Send(&api.PackageName{Name: "widget"})
+}
+
+func (*fakeRegistry) GetPackage(ctx context.Context, req *api.GetPackageRequest) (*api.Package, error) {
+	if req.GetName() != "widget" {
+		return nil, status.Errorf(codes.NotFound, "package %s not found", req.GetName())
+	}
+
+	return &api.Package{
+		Name:               "widget",
+		DefaultChannelName: "beta",
+		Channels: []*api.Channel{
+			{Name: "stable", CsvName: "widget.v2"},
+			{Name: "beta", CsvName: "widget.v3"},
+		},
+	}, nil
+}
+
+func (*fakeRegistry) GetBundleForChannel(ctx context.Context, req *api.GetBundleInChannelRequest) (*api.Bundle, error) {
+	switch req.GetChannelName() {
+	case "stable":
+		return &api.Bundle{CsvName: "widget.v2", CsvJson: fakeCSV("widget.v2")}, nil
+	case "beta":
+		return &api.Bundle{CsvName: "widget.v3", CsvJson: "not json"}, nil
+	}
+
+	return nil, status.Errorf(codes.NotFound, "channel %s not found", req.GetChannelName())
+}
+
+func (*fakeRegistry) ListBundles(req *api.ListBundlesRequest, stream api.Registry_ListBundlesServer) error {
+	for _, bundle := range fakeBundles {
+		if err := stream.Send(bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newFakeRegistrySource(t *testing.T) *RegistrySource {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	api.RegisterRegistryServer(server, &fakeRegistry{})
+
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	src := NewRegistrySource("bufnet").WithDialOptions(
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	t.Cleanup(func() { src.Close() })
+
+	return src
+}
+
+func TestRegistrySourceListPackageManifests(t *testing.T) {
+	src := newFakeRegistrySource(t)
+
+	pkgs, err := src.ListPackageManifests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pkgs) != 1 {
+		t.Fatalf("expected 1 package, got %d", len(pkgs))
+	}
+
+	pkg := pkgs[0]
+	if pkg.Name != "widget" || pkg.Status.CatalogSource != "bufnet" {
+		t.Errorf("unexpected package %s from catalog %s", pkg.Name, pkg.Status.CatalogSource)
+	}
+
+	// The beta channel is elided, so the default falls back to stable.
+	if len(pkg.Status.Channels) != 1 || pkg.Status.Channels[0].CurrentCSV != "widget.v2" {
+		t.Errorf("unexpected channels %+v", pkg.Status.Channels)
+	}
+	if pkg.Status.DefaultChannel != "stable" {
+		t.Errorf("expected default channel stable, got %s", pkg.Status.DefaultChannel)
+	}
+	if pkg.Status.Provider.Name != "Acme" {
+		t.Errorf("expected provider Acme, got %s", pkg.Status.Provider.Name)
+	}
+}
+
+func TestRegistrySourceGetPackageManifest(t *testing.T) {
+	src := newFakeRegistrySource(t).WithCatalogInfo(CatalogInfo{Name: "community", Namespace: "olm"})
+
+	pkg, err := src.GetPackageManifest(context.Background(), "widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Status.CatalogSource != "community" || pkg.Status.CatalogSourceNamespace != "olm" {
+		t.Errorf("catalog info not applied: %+v", pkg.Status)
+	}
+
+	if _, err := src.GetPackageManifest(context.Background(), "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a missing package, got %v", err)
+	}
+}
+
+func TestRegistrySourceGetChannelEntries(t *testing.T) {
+	src := newFakeRegistrySource(t)
+
+	entries, err := src.GetChannelEntries(context.Background(), "widget", "stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[1].Name != "widget.v2" || entries[1].Replaces != "widget.v1" || len(entries[1].Skips) != 1 {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+
+	if _, err := src.GetChannelEntries(context.Background(), "widget", "alpha"); err == nil {
+		t.Error("expected an error for a missing channel")
+	}
+}
@@ -0,0 +1,113 @@
+package packagemanager
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+type (
+	// A CatalogPrioritySource is a Source that knows the priority of each
+	// catalog source. Priorities are keyed by namespace/name.
+	CatalogPrioritySource interface {
+		GetCatalogPriorities(ctx context.Context) (map[string]int, error)
+	}
+)
+
+// Split a package reference of the form [catalog/]package into its
+// catalog source and package name. The catalog source is empty if the
+// reference does not include one.
+func ParsePackageRef(ref string) (catalogSource, packageName string) {
+	if catalogSource, packageName, found := strings.Cut(ref, "/"); found {
+		return catalogSource, packageName
+	}
+
+	return "", ref
+}
+
+// Return the priority of each catalog source, if the Source is able to
+// provide them. Failure to retrieve priorities is not fatal; we simply
+// treat all catalogs as having the same priority.
+func (pm *PackageManager) getCatalogPriorities(ctx context.Context) map[string]int {
+	prioritySource, ok := pm.source.(CatalogPrioritySource)
+	if !ok {
+		return nil
+	}
+
+	priorities, err := getCached(ctx, pm, "catalogpriorities", prioritySource.GetCatalogPriorities)
+	if err != nil {
+		log.Printf("unable to retrieve catalog source priorities: %v", err)
+		return nil
+	}
+
+	return priorities
+}
+
+// Find a package by name. The reference may be qualified with a catalog
+// source (catalog/package), or the catalog source may be given
+// explicitly. If more than one catalog source provides the package we
+// prefer the one with the highest priority (as OLM does) and log a
+// warning.
+//
+// When the cached package list shows that at most one catalog source
+// provides the package we fetch just that package, which is much cheaper
+// on a slow link; this also finds packages added since the list was
+// cached. Otherwise (a catalog source was given, the name is ambiguous,
+// or there is no cached list) we list all packages, which lets us see
+// every catalog source that provides it.
+func (pm *PackageManager) FindPackageManifest(ctx context.Context, ref, catalogSource string) (*Package, error) {
+	refCatalogSource, packageName := ParsePackageRef(ref)
+	if refCatalogSource != "" {
+		if catalogSource != "" && catalogSource != refCatalogSource {
+			return nil, fmt.Errorf("conflicting catalog sources %s and %s", refCatalogSource, catalogSource)
+		}
+		catalogSource = refCatalogSource
+	}
+
+	if catalogSource == "" {
+		if idx := pm.cachedPackageIndex(); idx != nil && len(idx.Names[strings.ToLower(packageName)]) <= 1 {
+			return pm.GetPackageManifest(ctx, packageName)
+		}
+	}
+
+	candidates, err := pm.ListPackageManifests(ctx,
+		matchExactPackageName(packageName),
+		FilterFunc(func(pkg *operators.PackageManifest) bool {
+			return catalogSource == "" || pkg.Status.CatalogSource == catalogSource
+		}))
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(candidates) {
+	case 0:
+		if catalogSource != "" {
+			return nil, fmt.Errorf("package %s not found in catalog source %s", packageName, catalogSource)
+		}
+		return nil, fmt.Errorf("package %s not found", packageName)
+	case 1:
+		return &Package{candidates[0]}, nil
+	}
+
+	priorities := pm.getCatalogPriorities(ctx)
+	priority := func(pkg *operators.PackageManifest) int {
+		return priorities[pkg.Status.CatalogSourceNamespace+"/"+pkg.Status.CatalogSource]
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return priority(&candidates[i]) > priority(&candidates[j])
+	})
+
+	var names []string
+	for _, pkg := range candidates {
+		names = append(names, fmt.Sprintf("%s/%s", pkg.Status.CatalogSource, pkg.Name))
+	}
+	log.Printf("warning: package %s is ambiguous (%s); using %s",
+		packageName, strings.Join(names, ", "), names[0])
+
+	return &Package{candidates[0]}, nil
+}
@@ -0,0 +1,225 @@
+package packagemanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+
+	// Register the sqlite3 database/sql driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type (
+	// A SQLiteSource synthesizes PackageManifests from a legacy
+	// operator-registry SQLite database (the index.db file found in older
+	// catalog images).
+	SQLiteSource struct {
+		path    string
+		catalog CatalogInfo
+	}
+)
+
+// Create a new SQLiteSource that reads the database at path. By default the
+// catalog is named after the file, without the extension.
+func NewSQLiteSource(path string) *SQLiteSource {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &SQLiteSource{
+		path: path,
+		catalog: CatalogInfo{
+			Name:        name,
+			DisplayName: name,
+		},
+	}
+}
+
+// Set the catalog information reported in synthesized PackageManifests.
+func (src *SQLiteSource) WithCatalogInfo(catalog CatalogInfo) *SQLiteSource {
+	src.catalog = catalog
+	return src
+}
+
+// Open the database in read-only mode. We check for the file explicitly
+// because otherwise the driver reports a somewhat cryptic error. A file:
+// URI with a relative path would name a host, so we use the absolute path,
+// which is escaped so that a "?" or "#" in it isn't taken as the start of
+// the query string.
+func (src *SQLiteSource) open() (*sql.DB, error) {
+	if _, err := os.Stat(src.path); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(src.path)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	return sql.Open("sqlite3", dsn.String())
+}
+
+// Build a PackageManifest for the named package.
+func (src *SQLiteSource) packageManifest(ctx context.Context, db *sql.DB, packageName, defaultChannel string) (*operators.PackageManifest, error) {
+	var channels []catalogChannel
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT channel.name, operatorbundle.csv
+		FROM channel
+		INNER JOIN operatorbundle ON channel.head_operatorbundle_name = operatorbundle.name
+		WHERE channel.package_name = ?
+		ORDER BY channel.name`, packageName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var csvJSON sql.NullString
+
+		if err := rows.Scan(&name, &csvJSON); err != nil {
+			return nil, err
+		}
+
+		if !csvJSON.Valid {
+			log.Printf("%s: eliding channel %s: no csv for channel head", packageName, name)
+			continue
+		}
+
+		channels = append(channels, catalogChannel{
+			name:    name,
+			csvJSON: csvJSON.String,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newPackageManifest(src.catalog, packageName, defaultChannel, channels)
+}
+
+func (src *SQLiteSource) GetPackageManifest(ctx context.Context, packageName string) (*operators.PackageManifest, error) {
+	var defaultChannel sql.NullString
+
+	db, err := src.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.QueryRowContext(ctx, `SELECT default_channel FROM package WHERE name = ?`, packageName).Scan(&defaultChannel)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("package %s not found", packageName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return src.packageManifest(ctx, db, packageName, defaultChannel.String)
+}
+
+func (src *SQLiteSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	var pkgs []operators.PackageManifest
+
+	db, err := src.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `SELECT name, default_channel FROM package ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read the list of packages before issuing any further queries so
+	// that we are not holding this result set open while doing so.
+	type packageRow struct {
+		name           string
+		defaultChannel sql.NullString
+	}
+
+	var packageRows []packageRow
+	for rows.Next() {
+		var row packageRow
+		if err := rows.Scan(&row.name, &row.defaultChannel); err != nil {
+			return nil, err
+		}
+		packageRows = append(packageRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, row := range packageRows {
+		manifest, err := src.packageManifest(ctx, db, row.name, row.defaultChannel.String)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("skipping package: %v", err)
+			continue
+		}
+		pkgs = append(pkgs, *manifest)
+	}
+
+	return pkgs, nil
+}
+
+func (src *SQLiteSource) GetChannelEntries(ctx context.Context, packageName, channelName string) ([]ChannelEntry, error) {
+	var entries []ChannelEntry
+
+	db, err := src.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// The channel_entry table contains additional rows for skipped
+	// bundles, so we group by bundle name and read replaces/skips
+	// from the operatorbundle table instead.
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry.operatorbundle_name, bundle.version, bundle.replaces, bundle.skips, bundle.skiprange
+		FROM channel_entry AS entry
+		INNER JOIN operatorbundle AS bundle ON entry.operatorbundle_name = bundle.name
+		WHERE entry.package_name = ? AND entry.channel_name = ?
+		GROUP BY entry.operatorbundle_name
+		ORDER BY MIN(entry.depth)`, packageName, channelName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry ChannelEntry
+		var version, replaces, skips, skipRange sql.NullString
+
+		if err := rows.Scan(&entry.Name, &version, &replaces, &skips, &skipRange); err != nil {
+			return nil, err
+		}
+
+		entry.Version = version.String
+		entry.Replaces = replaces.String
+		entry.SkipRange = skipRange.String
+		if skips.String != "" {
+			entry.Skips = strings.Split(skips.String, ",")
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("channel %s not found", channelName)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,77 @@
+package packagemanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteSource(t *testing.T) {
+	src := NewSQLiteSource("testdata/index.db")
+	ctx := context.Background()
+
+	pkgs, err := src.ListPackageManifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd" {
+		t.Errorf("expected etcd, got %s", names)
+	}
+
+	pkg, err := src.GetPackageManifest(ctx, "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Status.CatalogSource != "index" || pkg.Status.DefaultChannel != "singlenamespace-alpha" || pkg.Status.Provider.Name != "CNCF" {
+		t.Errorf("unexpected package %+v", pkg.Status)
+	}
+	if len(pkg.Status.Channels) != 1 || pkg.Status.Channels[0].CurrentCSV != "etcdoperator.v0.9.4" {
+		t.Errorf("unexpected channels %+v", pkg.Status.Channels)
+	}
+
+	if _, err := src.GetPackageManifest(ctx, "missing"); err == nil {
+		t.Error("expected an error for a missing package")
+	}
+
+	// The skipped bundle has its own row in channel_entry, but is only
+	// reported once.
+	entries, err := src.GetChannelEntries(ctx, "etcd", "singlenamespace-alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ChannelEntry{
+		{Name: "etcdoperator.v0.9.4", Version: "0.9.4", Replaces: "etcdoperator.v0.9.2", Skips: []string{"etcdoperator.v0.9.0"}, SkipRange: "<0.9.2"},
+		{Name: "etcdoperator.v0.9.2", Version: "0.9.2"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	if _, err := NewSQLiteSource("testdata/missing.db").ListPackageManifests(ctx); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+func TestSQLiteSourcePath(t *testing.T) {
+	data, err := os.ReadFile("testdata/index.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Characters that mean something in a URI must not be taken as
+	// such.
+	path := filepath.Join(t.TempDir(), "my index?v=1#100%.db")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := NewSQLiteSource(path).ListPackageManifests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(pkgs); names != "etcd" {
+		t.Errorf("expected etcd, got %s", names)
+	}
+}
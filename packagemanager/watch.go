@@ -0,0 +1,328 @@
+package packagemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+type (
+	PackageEventType string
+
+	// A PackageEvent describes a single change to the set of available
+	// packages. Packages are identified as catalogsource/name. Old and
+	// New hold the previous and current value of whatever changed (a
+	// channel head or the default channel).
+	PackageEvent struct {
+		Type    PackageEventType `json:"type"`
+		Package string           `json:"package"`
+		Channel string           `json:"channel,omitempty"`
+		Old     string           `json:"old,omitempty"`
+		New     string           `json:"new,omitempty"`
+	}
+
+	// A WatchSource is a Source that can stream changes to
+	// PackageManifests. WatchPackageManifests calls fn for each change
+	// until ctx is cancelled or the server closes the watch (in which
+	// case it returns nil). eventType is one of ADDED, MODIFIED or
+	// DELETED.
+	WatchSource interface {
+		WatchPackageManifests(ctx context.Context, fn func(eventType string, pkg *operators.PackageManifest) error) error
+	}
+
+	// A ReloadableSource is a Source that keeps packages in memory
+	// once it has read them. Reload makes it read them again, so that
+	// polling sees changes.
+	ReloadableSource interface {
+		Reload()
+	}
+
+	// A snapshot of the packages we are watching, keyed by
+	// catalogsource/name.
+	packageSnapshot map[string]operators.PackageManifest
+
+	// An error returned by the function passed to Watch, which a
+	// WatchSource hands back to us. We wrap it so that we can tell it
+	// apart from a failed watch.
+	watchCallbackError struct {
+		err error
+	}
+)
+
+const (
+	EventPackageAdded          PackageEventType = "PackageAdded"
+	EventPackageRemoved        PackageEventType = "PackageRemoved"
+	EventDefaultChannelChanged PackageEventType = "DefaultChannelChanged"
+	EventChannelAdded          PackageEventType = "ChannelAdded"
+	EventChannelRemoved        PackageEventType = "ChannelRemoved"
+	EventChannelHeadChanged    PackageEventType = "ChannelHeadChanged"
+)
+
+// Returned by a WatchSource when the server does not support watching
+// PackageManifests.
+var ErrWatchUnsupported = errors.New("watch is not supported")
+
+// A watch that the server closes sooner than this after it started is
+// treated as a failure to watch: if it happens repeatedly, we wait before
+// watching again, so that a server that closes watches immediately doesn't
+// have us downloading the whole catalog in a tight loop.
+const minWatchDuration = 10 * time.Second
+
+func (e *watchCallbackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *watchCallbackError) Unwrap() error {
+	return e.err
+}
+
+// Return a human-readable description of the event.
+func (ev PackageEvent) String() string {
+	switch ev.Type {
+	case EventPackageAdded:
+		return fmt.Sprintf("package %s added", ev.Package)
+	case EventPackageRemoved:
+		return fmt.Sprintf("package %s removed", ev.Package)
+	case EventDefaultChannelChanged:
+		return fmt.Sprintf("default channel of %s changed from %s to %s", ev.Package, ev.Old, ev.New)
+	case EventChannelAdded:
+		return fmt.Sprintf("channel %s added to %s (%s)", ev.Channel, ev.Package, ev.New)
+	case EventChannelRemoved:
+		return fmt.Sprintf("channel %s removed from %s (%s)", ev.Channel, ev.Package, ev.Old)
+	case EventChannelHeadChanged:
+		return fmt.Sprintf("channel head of %s (%s) moved from %s to %s", ev.Package, ev.Channel, ev.Old, ev.New)
+	}
+
+	return fmt.Sprintf("%s %s", ev.Type, ev.Package)
+}
+
+// Convert a PackageDiff into a list of events.
+func (pkgDiff *PackageDiff) events() []PackageEvent {
+	var events []PackageEvent
+
+	if pkgDiff.OldDefaultChannel != pkgDiff.NewDefaultChannel {
+		events = append(events, PackageEvent{
+			Type:    EventDefaultChannelChanged,
+			Package: pkgDiff.Package,
+			Old:     pkgDiff.OldDefaultChannel,
+			New:     pkgDiff.NewDefaultChannel,
+		})
+	}
+
+	for _, channel := range pkgDiff.AddedChannels {
+		events = append(events, PackageEvent{
+			Type:    EventChannelAdded,
+			Package: pkgDiff.Package,
+			Channel: channel.Name,
+			New:     channel.CurrentCSV,
+		})
+	}
+
+	for _, channel := range pkgDiff.RemovedChannels {
+		events = append(events, PackageEvent{
+			Type:    EventChannelRemoved,
+			Package: pkgDiff.Package,
+			Channel: channel.Name,
+			Old:     channel.CurrentCSV,
+		})
+	}
+
+	for _, change := range pkgDiff.ChangedHeads {
+		events = append(events, PackageEvent{
+			Type:    EventChannelHeadChanged,
+			Package: pkgDiff.Package,
+			Channel: change.Channel,
+			Old:     change.OldCSV,
+			New:     change.NewCSV,
+		})
+	}
+
+	return events
+}
+
+// Return true if pkg is selected by all of the filters.
+func matchFilters(pkg *operators.PackageManifest, filters []PackageManifestFilter) bool {
+	for _, filter := range filters {
+		if !filter.Match(pkg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Build a snapshot from the packages matching filters.
+func newPackageSnapshot(pkgs []operators.PackageManifest, filters []PackageManifestFilter) packageSnapshot {
+	snapshot := make(packageSnapshot)
+	for i := range pkgs {
+		if matchFilters(&pkgs[i], filters) {
+			snapshot[packageKey(&pkgs[i], nil)] = pkgs[i]
+		}
+	}
+
+	return snapshot
+}
+
+// Record a new version of a single package (or its removal, if pkg is
+// nil) and return the resulting events.
+func (snapshot packageSnapshot) update(key string, pkg *operators.PackageManifest) []PackageEvent {
+	oldPkg, existed := snapshot[key]
+
+	switch {
+	case pkg == nil && existed:
+		delete(snapshot, key)
+		return []PackageEvent{{Type: EventPackageRemoved, Package: key}}
+	case pkg == nil:
+		return nil
+	case !existed:
+		snapshot[key] = *pkg
+		return []PackageEvent{{Type: EventPackageAdded, Package: key}}
+	}
+
+	snapshot[key] = *pkg
+
+	// If the server tells us the package hasn't changed, believe it.
+	if pkg.ResourceVersion != "" && pkg.ResourceVersion == oldPkg.ResourceVersion {
+		return nil
+	}
+
+	if pkgDiff := diffPackage(key, &oldPkg, pkg); pkgDiff != nil {
+		return pkgDiff.events()
+	}
+
+	return nil
+}
+
+// Replace the contents of the snapshot and return the resulting events.
+func (snapshot packageSnapshot) replace(other packageSnapshot) []PackageEvent {
+	var events []PackageEvent
+
+	var keys []string
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	for key := range other {
+		if _, ok := snapshot[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if pkg, ok := other[key]; ok {
+			events = append(events, snapshot.update(key, &pkg)...)
+		} else {
+			events = append(events, snapshot.update(key, nil)...)
+		}
+	}
+
+	return events
+}
+
+// Watch for changes to packages matching the given filters and call fn
+// for each change, until ctx is cancelled or fn returns an error. If the
+// Source supports watches we use them; otherwise (or if the server
+// refuses to watch) we list all packages every interval and compare the
+// results. Watching bypasses the cache.
+func (pm *PackageManager) Watch(ctx context.Context, interval time.Duration, fn func(PackageEvent) error, filters ...PackageManifestFilter) error {
+	pkgs, err := pm.source.ListPackageManifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := newPackageSnapshot(pkgs, filters)
+	log.Printf("watching %d packages", len(snapshot))
+
+	emit := func(events []PackageEvent) error {
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Apply a watch event to the snapshot. A package that is modified so
+	// that it no longer matches the filters is treated as removed.
+	onWatchEvent := func(eventType string, pkg *operators.PackageManifest) error {
+		key := packageKey(pkg, nil)
+		if eventType == "DELETED" || !matchFilters(pkg, filters) {
+			pkg = nil
+		}
+		if err := emit(snapshot.update(key, pkg)); err != nil {
+			return &watchCallbackError{err}
+		}
+		return nil
+	}
+
+	watchSource, canWatch := pm.source.(WatchSource)
+	quickCloses := 0
+
+	for {
+		wait := true
+
+		if canWatch {
+			started := time.Now()
+			err := watchSource.WatchPackageManifests(ctx, onWatchEvent)
+			var callbackErr *watchCallbackError
+			switch {
+			case errors.As(err, &callbackErr):
+				return callbackErr.err
+			case errors.Is(err, ErrWatchUnsupported):
+				log.Printf("server does not support watch; polling every %s", interval)
+				canWatch = false
+			case err == nil:
+				// The server closed the watch. Resynchronize and
+				// start a new one right away, unless it keeps
+				// closing them as soon as they start.
+				if time.Since(started) < minWatchDuration {
+					quickCloses++
+				} else {
+					quickCloses = 0
+				}
+				wait = quickCloses > 1
+				if wait {
+					log.Printf("server closed the watch after %s; waiting %s before watching again",
+						time.Since(started).Round(time.Millisecond), interval)
+				}
+			case ctx.Err() == nil:
+				log.Printf("watch failed: %v", err)
+			}
+		}
+
+		if wait {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Poll, or resynchronize after the watch ended so that we
+		// don't miss changes that happened while we weren't watching.
+		if reloadable, ok := pm.source.(ReloadableSource); ok {
+			reloadable.Reload()
+		}
+
+		pkgs, err := pm.source.ListPackageManifests(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("failed to list packages: %v", err)
+			continue
+		}
+
+		if err := emit(snapshot.replace(newPackageSnapshot(pkgs, filters))); err != nil {
+			return err
+		}
+	}
+}
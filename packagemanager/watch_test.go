@@ -0,0 +1,144 @@
+package packagemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	operators "github.com/operator-framework/operator-lifecycle-manager/pkg/package-server/apis/operators/v1"
+)
+
+// A WatchSource whose watches end as soon as they start.
+type closingWatchSource struct {
+	NullSource
+
+	mu    sync.Mutex
+	lists int
+}
+
+func (src *closingWatchSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	src.lists++
+	return nil, nil
+}
+
+func (src *closingWatchSource) WatchPackageManifests(ctx context.Context, fn func(string, *operators.PackageManifest) error) error {
+	return nil
+}
+
+func TestWatchBacksOffWhenServerClosesWatches(t *testing.T) {
+	src := &closingWatchSource{}
+	pm := NewPackageManager(src)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	err := pm.Watch(ctx, 200*time.Millisecond, func(PackageEvent) error { return nil })
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the watch to end with the context, got %v", err)
+	}
+
+	// The initial list, an immediate resynchronization after the first
+	// close, and then one every interval.
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.lists > 5 {
+		t.Errorf("listed packages %d times in 500ms", src.lists)
+	}
+}
+
+// A WatchSource that reports the same package over and over.
+type repeatingWatchSource struct {
+	NullSource
+}
+
+func (src *repeatingWatchSource) ListPackageManifests(ctx context.Context) ([]operators.PackageManifest, error) {
+	return nil, nil
+}
+
+func (src *repeatingWatchSource) WatchPackageManifests(ctx context.Context, fn func(string, *operators.PackageManifest) error) error {
+	pkg := testPackage("", "community", "flux", "flux.v1")
+
+	for ctx.Err() == nil {
+		if err := fn("ADDED", &pkg); err != nil {
+			return err
+		}
+		if err := fn("DELETED", &pkg); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+func TestWatchStopsWhenCallbackFails(t *testing.T) {
+	pm := NewPackageManager(&repeatingWatchSource{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := pm.Watch(ctx, time.Second, func(PackageEvent) error {
+		calls++
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected the watch to end with the callback's error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("callback called %d times after it failed", calls-1)
+	}
+}
+
+func writePackageFile(t *testing.T, path, head string) {
+	t.Helper()
+
+	doc := fmt.Sprintf(`{"apiVersion":"packages.operators.coreos.com/v1","kind":"PackageManifest",
+		"metadata":{"name":"flux"},
+		"status":{"catalogSource":"community","defaultChannel":"stable",
+			"channels":[{"name":"stable","currentCSV":%q}]}}`, head)
+
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchPollingRereadsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flux.json")
+	writePackageFile(t, path, "flux.v1")
+
+	pm := NewPackageManager(NewFileSource(path))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan PackageEvent, 10)
+	go func() {
+		//nolint:errcheck
+		pm.Watch(ctx, 50*time.Millisecond, func(event PackageEvent) error {
+			events <- event
+			return nil
+		})
+	}()
+
+	// Give the watch time to read the original file.
+	time.Sleep(200 * time.Millisecond)
+	writePackageFile(t, path, "flux.v2")
+
+	select {
+	case event := <-events:
+		if event.Type != EventChannelHeadChanged || event.Old != "flux.v1" || event.New != "flux.v2" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-ctx.Done():
+		t.Fatal("no event after the file changed")
+	}
+}